@@ -0,0 +1,242 @@
+package filestorage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileAccess is the download statistics of a file.
+type FileAccess struct {
+	Hash           string    `json:"hash"`
+	Count          int64     `json:"count"`
+	LastAccessedAt time.Time `json:"lastAccessedAt"`
+}
+
+func (b *Bucket) createAccessesTable(db DB) error {
+	if b.AccessesTable == "" {
+		b.AccessesTable = "file_accesses"
+	}
+	_, err := b.getDB(db).Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		file             text        NOT NULL,
+		object           text        NOT NULL,
+		count            int8        NOT NULL,
+		last_accessed_at timestamptz NOT NULL,
+		unique(file, object)
+	);
+	CREATE INDEX IF NOT EXISTS %s_object_index ON %s(object);
+	`, b.AccessesTable, b.AccessesTable, b.AccessesTable,
+	))
+	return err
+}
+
+type accessKey struct {
+	file, object string
+}
+
+type accessValue struct {
+	count int64
+	last  time.Time
+}
+
+// accessRecorder collects accesses in memory, and writes them to database in batch.
+// It's created by Init, and accesses is nil until access stats is started.
+type accessRecorder struct {
+	mutex    sync.Mutex
+	accesses map[accessKey]accessValue
+}
+
+/*
+StartAccessStats starts recording download counts and last access time of files downloaded by
+Download, GetFile and ReadFile, per file and per link object. Accesses are collected in memory,
+and written to AccessesTable in batch every flushInterval, so downloads are not slowed down.
+Accesses collected after the last flush are lost if the process exits without FlushAccesses.
+Only successful downloads are counted, and an image variant is counted by the hash of the variant.
+It must be called after Init.
+*/
+func (b *Bucket) StartAccessStats(flushInterval time.Duration, logger Logger) {
+	r := b.accesses
+	if flushInterval <= 0 || r == nil {
+		return
+	}
+	r.mutex.Lock()
+	started := r.accesses != nil
+	if !started {
+		r.accesses = make(map[accessKey]accessValue)
+	}
+	r.mutex.Unlock()
+	if started {
+		return
+	}
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error(err)
+			}
+		}()
+		for {
+			time.Sleep(flushInterval)
+			if err := b.FlushAccesses(); err != nil {
+				logger.Error(err)
+			}
+		}
+	}()
+}
+
+// recordAccess records an access of a file if access stats is started.
+func (b *Bucket) recordAccess(file, object string) {
+	r := b.accesses
+	if r == nil {
+		return
+	}
+	key := accessKey{file: file, object: object}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.accesses == nil {
+		return
+	}
+	value := r.accesses[key]
+	value.count++
+	value.last = time.Now()
+	r.accesses[key] = value
+}
+
+// statusRecorder records the status written by a delivery, so only successful downloads are counted.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the sendfile optimization of the underlying writer.
+func (w *statusRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// succeeded returns true if the file is sent in full or in part, a delivery that writes no status,
+// like XAccel, succeeds with the implicit 200 status.
+func (w *statusRecorder) succeeded() bool {
+	return w.status == 0 || w.status == http.StatusOK || w.status == http.StatusPartialContent
+}
+
+// FlushAccesses writes the accesses collected in memory to database.
+// If writing fails, the accesses are kept to write next time.
+func (b *Bucket) FlushAccesses() error {
+	r := b.accesses
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	accesses := r.accesses
+	if accesses != nil {
+		r.accesses = make(map[accessKey]accessValue)
+	}
+	r.mutex.Unlock()
+	if len(accesses) == 0 {
+		return nil
+	}
+
+	var values []string
+	for key, value := range accesses {
+		values = append(values, fmt.Sprintf("(%s, %s, %d, %s)",
+			quote(key.file), quote(key.object), value.count, fmtTime(value.last),
+		))
+	}
+	// sorted to lock rows in the same order with other processes.
+	sort.Strings(values)
+	_, err := b.DB.Exec(fmt.Sprintf(`
+	INSERT INTO %s AS t (file, object, count, last_accessed_at)
+	VALUES %s
+	ON CONFLICT (file, object) DO UPDATE SET
+		count = t.count + EXCLUDED.count,
+		last_accessed_at = GREATEST(t.last_accessed_at, EXCLUDED.last_accessed_at)
+	`, b.AccessesTable, strings.Join(values, ", "),
+	))
+	if err != nil {
+		r.mutex.Lock()
+		for key, value := range accesses {
+			current := r.accesses[key]
+			current.count += value.count
+			if value.last.After(current.last) {
+				current.last = value.last
+			}
+			r.accesses[key] = current
+		}
+		r.mutex.Unlock()
+	}
+	return err
+}
+
+// TopFiles returns the most downloaded files, counted across all link objects.
+func (b *Bucket) TopFiles(db DB, limit int) ([]FileAccess, error) {
+	return b.queryAccesses(db, fmt.Sprintf(`
+	SELECT file, sum(count), max(last_accessed_at) FROM %s
+	GROUP BY file
+	ORDER BY sum(count) DESC, file
+	LIMIT %d
+	`, b.AccessesTable, limit,
+	))
+}
+
+// ObjectAccesses returns the download statistics of files downloaded by the link object.
+func (b *Bucket) ObjectAccesses(db DB, object string) ([]FileAccess, error) {
+	return b.queryAccesses(db, fmt.Sprintf(`
+	SELECT file, count, last_accessed_at FROM %s
+	WHERE object = %s
+	ORDER BY count DESC, file
+	`, b.AccessesTable, quote(object),
+	))
+}
+
+// NeverAccessedFiles returns files created before createdBefore and never downloaded, image variants
+// are not included. They may be candidates to move to a cold storage or to clean up.
+func (b *Bucket) NeverAccessedFiles(db DB, createdBefore time.Time, limit int) ([]string, error) {
+	return b.queryFiles(db, fmt.Sprintf(`
+	SELECT hash FROM %s f
+	WHERE created_at < %s AND parent = '' AND NOT EXISTS (
+	  SELECT 1 FROM %s WHERE file = f.hash
+	)
+	ORDER BY created_at, hash
+	LIMIT %d
+	`, b.FilesTable, fmtTime(createdBefore), b.AccessesTable, limit,
+	))
+}
+
+func (b *Bucket) queryAccesses(db DB, sql string) ([]FileAccess, error) {
+	rows, err := b.getDB(db).Query(sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []FileAccess
+	for rows.Next() {
+		var access FileAccess
+		if err := rows.Scan(&access.Hash, &access.Count, &access.LastAccessedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, access)
+	}
+	return result, rows.Err()
+}
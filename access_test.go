@@ -0,0 +1,37 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func ExampleBucket_TopFiles() {
+	hashes, err := testBucket.Save(nil, nil, "accessed", File{IO: strings.NewReader("access"), Size: 6})
+	if err != nil {
+		panic(err)
+	}
+	testBucket.StartAccessStats(time.Hour, nil)
+	for i := 0; i < 2; i++ {
+		if _, err := testBucket.ReadFile(nil, hashes[0], "accessed"); err != nil {
+			panic(err)
+		}
+	}
+	if err := testBucket.Download(nil, httptest.NewRecorder(), hashes[0], ""); err != nil {
+		panic(err)
+	}
+	fmt.Println(testBucket.FlushAccesses())
+
+	top, err := testBucket.TopFiles(nil, 1)
+	fmt.Println(len(top), top[0].Hash == hashes[0], top[0].Count, err)
+	accesses, err := testBucket.ObjectAccesses(nil, "accessed")
+	fmt.Println(len(accesses), accesses[0].Count, err)
+	never, err := testBucket.NeverAccessedFiles(nil, time.Now(), 100)
+	fmt.Println(len(never) > 0, strings.Contains(strings.Join(never, ","), hashes[0]), err)
+	// Output:
+	// <nil>
+	// 1 true 3 <nil>
+	// 1 2 <nil>
+	// false false <nil>
+}
@@ -0,0 +1,166 @@
+package filestorage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ActiveContentPolicy decides how to handle files of active content types, like html and svg,
+// which may run scripts in browsers, and cause stored XSS if they are served inline from our domain.
+type ActiveContentPolicy uint8
+
+const (
+	// Active content is stored as is, but always downloaded as an attachment.
+	ActiveContentForceDownload ActiveContentPolicy = iota
+	// Active content is rejected on upload.
+	ActiveContentReject
+	// SVG images are sanitized on upload by stripping scripts and event handlers,
+	// and served inline; other active content is downloaded as an attachment.
+	ActiveContentSanitize
+)
+
+// the Content-Security-Policy header for active content, scripts, plugins and forms are all disabled.
+const activeContentCSP = "default-src 'none'; img-src data:; style-src 'unsafe-inline'; sandbox"
+
+var activeContentTypes = map[string]bool{
+	"text/html":             true,
+	"application/xhtml+xml": true,
+	"image/svg+xml":         true,
+	"text/xml":              true,
+	"application/xml":       true,
+}
+
+// IsActiveContent checks if a content type may run scripts in browsers.
+func IsActiveContent(contentType string) bool {
+	return activeContentTypes[baseMimeType(contentType)]
+}
+
+func (b *Bucket) isSanitizable(contentType string) bool {
+	return b.ActiveContent == ActiveContentSanitize && baseMimeType(contentType) == "image/svg+xml"
+}
+
+// handleActiveContent rejects or sanitizes active content according to the ActiveContent policy.
+// If the file is sanitized, the sanitized content is returned, otherwise nil is returned.
+func (b *Bucket) handleActiveContent(lang, contentType string, file io.Reader) (*bytes.Reader, error) {
+	if !IsActiveContent(contentType) {
+		return nil, nil
+	}
+	switch {
+	case b.ActiveContent == ActiveContentReject:
+		return nil, localize(
+			lang, "不允许上传可执行脚本的文件.", "files that may run scripts are not allowed.",
+		).SetData(contentType)
+	case b.isSanitizable(contentType):
+		var buf bytes.Buffer
+		if err := SanitizeSVG(&buf, file); err != nil {
+			return nil, localize(lang, "无法识别的SVG图片.", "unrecognized svg image.").SetData(err.Error())
+		}
+		return bytes.NewReader(buf.Bytes()), nil
+	}
+	return nil, nil
+}
+
+// writeActiveContentHeader writes headers to prevent active content from running scripts.
+func (b *Bucket) writeActiveContentHeader(resp http.ResponseWriter, contentType string) {
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	if IsActiveContent(contentType) {
+		resp.Header().Set("Content-Security-Policy", activeContentCSP)
+	}
+}
+
+// forceAttachment returns if a file must be downloaded as an attachment, instead of displayed inline.
+func (b *Bucket) forceAttachment(contentType string) bool {
+	return IsActiveContent(contentType) && !b.isSanitizable(contentType)
+}
+
+var svgUnsafeElements = map[string]bool{
+	"script": true, "foreignobject": true, "iframe": true, "object": true, "embed": true,
+	"handler": true, "listener": true,
+}
+
+/*
+SanitizeSVG copies an svg image from src to dst, with scripts and event handlers stripped:
+ 1. script, foreignObject, iframe, object, embed, handler and listener elements are removed.
+ 2. on* event handler attributes are removed.
+ 3. attributes whose value is a "javascript:" or non-image "data:" url are removed.
+ 4. processing instructions except the xml declaration, and directives like DOCTYPE are removed.
+*/
+func SanitizeSVG(dst io.Writer, src io.Reader) error {
+	decoder := xml.NewDecoder(src)
+	encoder := xml.NewEncoder(dst)
+	var skipDepth int
+	for {
+		// RawToken keeps namespace prefixes as is, so the output is the same as the input.
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if skipDepth > 0 || svgUnsafeElements[strings.ToLower(t.Name.Local)] {
+				skipDepth++
+				continue
+			}
+			token = sanitizeSVGElement(t)
+		case xml.EndElement:
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			t.Name = flattenXMLName(t.Name)
+			token = t
+		case xml.ProcInst:
+			if t.Target != "xml" {
+				continue
+			}
+		case xml.Directive:
+			continue
+		}
+		if skipDepth > 0 {
+			continue
+		}
+		if err := encoder.EncodeToken(xml.CopyToken(token)); err != nil {
+			return err
+		}
+	}
+	return encoder.Flush()
+}
+
+func sanitizeSVGElement(elem xml.StartElement) xml.StartElement {
+	var attrs []xml.Attr
+	for _, attr := range elem.Attr {
+		if strings.HasPrefix(strings.ToLower(attr.Name.Local), "on") || isUnsafeURL(attr.Value) {
+			continue
+		}
+		attr.Name = flattenXMLName(attr.Name)
+		attrs = append(attrs, attr)
+	}
+	elem.Name, elem.Attr = flattenXMLName(elem.Name), attrs
+	return elem
+}
+
+func isUnsafeURL(value string) bool {
+	// browsers ignore whitespaces and control characters in url schemes.
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+	return strings.Contains(value, "javascript:") || strings.Contains(value, "vbscript:") ||
+		strings.HasPrefix(value, "data:") && !strings.HasPrefix(value, "data:image/") ||
+		strings.HasPrefix(value, "data:image/svg")
+}
+
+// flattenXMLName makes the encoder write the prefix as is, instead of treating it as a namespace url.
+func flattenXMLName(name xml.Name) xml.Name {
+	if name.Space != "" {
+		return xml.Name{Local: name.Space + ":" + name.Local}
+	}
+	return name
+}
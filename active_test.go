@@ -0,0 +1,31 @@
+package filestorage
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func ExampleSanitizeSVG() {
+	err := SanitizeSVG(os.Stdout, strings.NewReader(`<svg xmlns="http://www.w3.org/2000/svg" `+
+		`xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">`+
+		`<script>alert(2)</script>`+
+		`<a xlink:href="javascript:alert(3)"><rect onclick="alert(4)" x="1"/></a>`+
+		`<foreignObject><div><script>alert(5)</script></div></foreignObject>`+
+		`</svg>`))
+	fmt.Println()
+	fmt.Println(err)
+	// Output:
+	// <svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink"><a><rect x="1"></rect></a></svg>
+	// <nil>
+}
+
+func ExampleIsActiveContent() {
+	fmt.Println(IsActiveContent("text/html; charset=utf-8"))
+	fmt.Println(IsActiveContent("image/svg+xml"))
+	fmt.Println(IsActiveContent("image/png"))
+	// Output:
+	// true
+	// true
+	// false
+}
@@ -0,0 +1,73 @@
+package filestorage
+
+import (
+	"net/http"
+
+	"github.com/lovego/errs"
+)
+
+// Operation is an operation on files of a link object.
+type Operation string
+
+const (
+	OpDownload   Operation = "download"    // Download files linked to an object.
+	OpUploadLink Operation = "upload-link" // Upload files and link them to an object.
+	OpUnlink     Operation = "unlink"      // Unlink files from an object.
+)
+
+// Authorizer decides if the user of a request may perform an operation on files of an object.
+// Link objects must be in the LinkObject format, and the object is zero if the request has no link object.
+// Returning false denies the request, and returning an error means the decision can't be made.
+type Authorizer interface {
+	Authorize(req *http.Request, object LinkObject, op Operation) (bool, error)
+}
+
+// AuthorizeFunc is an adapter to allow the use of ordinary functions as Authorizer.
+type AuthorizeFunc func(req *http.Request, object LinkObject, op Operation) (bool, error)
+
+func (f AuthorizeFunc) Authorize(req *http.Request, object LinkObject, op Operation) (bool, error) {
+	return f(req, object, op)
+}
+
+var errForbidden = errs.New("forbidden", "access denied")
+
+// IsForbidden check if an error is the error of a request denied by Authorizer.
+func IsForbidden(err error) bool {
+	return err == errForbidden
+}
+
+// Authorize authorizes an operation on files of object by Authorizer, if Authorizer is present.
+// It's called by Download, Open, GetFile and the upload functions, and should be called by
+// other handlers that link or unlink files, a denied request should be responded with 403.
+func (b *Bucket) Authorize(req *http.Request, object string, op Operation) error {
+	if b.Authorizer == nil {
+		return nil
+	}
+	var o LinkObject
+	if object != "" {
+		if err := o.UnmarshalJSON([]byte(object)); err != nil {
+			return err
+		}
+	}
+	if ok, err := b.Authorizer.Authorize(req, o, op); err != nil {
+		return err
+	} else if !ok {
+		return errForbidden
+	}
+	return nil
+}
+
+// authorizeMutation authorizes an operation that changes links of object like Authorize,
+// but it's denied if Authorizer is absent, since anonymous requests shouldn't change links.
+func (b *Bucket) authorizeMutation(req *http.Request, object string, op Operation) error {
+	if b.Authorizer == nil {
+		return errForbidden
+	}
+	return b.Authorize(req, object, op)
+}
+
+// canListObject returns if requests can get all files of an object, like zip downloads and stat.
+// Link objects are easy to guess, so Authorizer or signed urls are required.
+func (b *Bucket) canListObject() bool {
+	return b.Authorizer != nil || b.DownloadURLExpiry > 0
+}
@@ -0,0 +1,25 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+)
+
+func ExampleBucket_Authorize() {
+	bucket := Bucket{Authorizer: AuthorizeFunc(func(req *http.Request, o LinkObject, op Operation) (bool, error) {
+		// everyone can download, but only the owner can upload.
+		return op == OpDownload || o.Table == "users" && fmt.Sprint(o.ID) == req.Header.Get("User"), nil
+	})}
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("User", "1")
+	fmt.Println(bucket.Authorize(req, "users|2|avatar", OpDownload))
+	fmt.Println(bucket.Authorize(req, "users|1|avatar", OpUploadLink))
+	fmt.Println(bucket.Authorize(req, "users|2|avatar", OpUploadLink))
+	fmt.Println(bucket.Authorize(req, "users", OpUploadLink))
+	// Output:
+	// <nil>
+	// <nil>
+	// forbidden: access denied
+	// args-err: invalid LinkObject
+}
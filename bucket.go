@@ -4,8 +4,11 @@ package filestorage
 import (
 	"database/sql"
 	"errors"
+	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/lovego/errs"
 )
@@ -21,19 +24,71 @@ type Bucket struct {
 	ScpUser  string
 
 	DownloadURLPrefix string
+	// Hosts of download urls, like the hosts of CDNs. A host is selected by the hash of a file,
+	// so the url of a file is always on the same host. If empty, the host of DownloadURLPrefix is used.
+	DownloadHosts []string
+	// Hosts of download urls of regions, selected by URLOptions.Region in the same way as DownloadHosts.
+	RegionHosts map[string][]string
+	// If true, download urls are like "{DownloadURLPrefix}/{bucket}/{hash}?o=...", which are friendly to CDNs.
+	PathStyleURLs bool
+	// If present, CDN caches of deleted files are purged by it, PathStyleURLs is required.
+	Purger Purger
 
 	// Path prefix for "X-Accel-Redirect" response header when downloading.
 	// If this path prefix is present, only file path is sent in the "X-Accel-Redirect" header,
 	// and nginx is responsible for file downloading for a better performance.
 	// Otherwise, file is sent directly in the response body.
+	// It's a shortcut of Delivery: &XAccel{PathPrefix: RedirectPathPrefix}.
 	RedirectPathPrefix string
-
-	DB         DB
-	FilesTable string
-	LinksTable string
+	// How to send files in download responses, like XAccel, XSendfile or DirectSend.
+	// It defaults to XAccel if RedirectPathPrefix is present, or DirectSend.
+	Delivery Delivery
+
+	// If true, files missing locally and read from other machines are also saved locally.
+	BackfillReplicas bool
+	// Bytes per second of all files sent directly by the bucket, unlimited if 0.
+	RateLimit int64
+	// Bytes per second of files sent directly to a client(by ClientIP), unlimited if 0.
+	// It's also sent as the "X-Accel-Limit-Rate" header by XAccel if XAccel.LimitRate is 0,
+	// which limits each download instead of each client.
+	ClientRateLimit int64
+	// Max concurrent downloads sent directly by the bucket, more downloads are responded with 503.
+	MaxDownloads int
+
+	// Named policies to check uploaded files, selected by the server, like Handler.Policy and upload tokens.
+	Policies map[string]Policy
+	// How to handle files that may run scripts in browsers, like html and svg.
+	ActiveContent ActiveContentPolicy
+	// If present, uploaded files are scanned for malware, and infected files are rejected.
+	Scanner Scanner
+	// If present, uploaded files are processed before stored, like ImageNormalizer.
+	// The hash of an original file is kept as an alias of the processed one, so it can still be used.
+	Processor Processor
+	// Image variants allowed in unsigned download urls, other variants are rejected, so clients can't
+	// generate and store unlimited variants. Variants in signed download urls are always allowed.
+	Variants []Variant
+	// Keys to sign upload tokens and download urls. The first key is used to sign, and all keys are used
+	// to verify, so a new key can be prepended, and the old one can be removed after signed tokens expire.
+	SignKeys []string
+	// If > 0, download urls are signed by SignKeys and expire after it,
+	// and Download, Open and GetFile reject requests with invalid or expired signatures.
+	DownloadURLExpiry time.Duration
+	// Returns the user of a request, it's required to verify download urls bound to users.
+	UserOf func(req *http.Request) string
+	// If present, it authorizes downloads and link mutations of requests, see Authorize.
+	Authorizer Authorizer
+
+	DB                  DB
+	FilesTable          string
+	LinksTable          string
+	UploadSessionsTable string
+	AliasesTable        string
+	AccessesTable       string
 
 	localMachine  bool
 	otherMachines []string
+	throttle      *throttle
+	accesses      *accessRecorder
 }
 
 // DB represents *sql.DB or *sql.Tx
@@ -61,6 +116,12 @@ func (b *Bucket) Init(db DB) error {
 	} else if b.DirDepth > 8 {
 		return errors.New("DirDepth at most be 8")
 	}
+	if b.DownloadURLExpiry > 0 && len(b.SignKeys) == 0 {
+		return errors.New("SignKeys is required to sign download urls")
+	}
+	if b.Purger != nil && !b.PathStyleURLs {
+		return errors.New("PathStyleURLs is required by Purger")
+	}
 	if b.RedirectPathPrefix != "" && b.RedirectPathPrefix[0] != '/' {
 		b.RedirectPathPrefix = "/" + b.RedirectPathPrefix
 	}
@@ -70,6 +131,18 @@ func (b *Bucket) Init(db DB) error {
 	if err := b.createLinksTable(db); err != nil {
 		return err
 	}
+	if err := b.createUploadSessionsTable(db); err != nil {
+		return err
+	}
+	if err := b.createAliasesTable(db); err != nil {
+		return err
+	}
+	if err := b.createAccessesTable(db); err != nil {
+		return err
+	}
+	if b.accesses == nil {
+		b.accesses = &accessRecorder{}
+	}
 	if err := b.parseMachines(); err != nil {
 		return err
 	}
@@ -105,7 +178,7 @@ func FileHash(str string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	hash := uri.Query().Get("f")
+	hash := urlFileHash(uri)
 	if err := CheckHash(hash); err != nil {
 		return "", err
 	}
@@ -137,9 +210,20 @@ func TryFileHash(str string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	hash := uri.Query().Get("f")
+	hash := urlFileHash(uri)
 	if !IsHash(hash) {
 		return "", nil
 	}
 	return hash, nil
 }
+
+// urlFileHash returns the file hash of a download url, including path-style urls.
+func urlFileHash(uri *url.URL) string {
+	if hash := uri.Query().Get("f"); hash != "" {
+		return hash
+	}
+	if hash := path.Base(uri.Path); IsHash(hash) {
+		return hash
+	}
+	return ""
+}
@@ -104,6 +104,95 @@ func ExampleBucket_LinkOnly() {
 	// false <nil>
 }
 
+func ExampleBucket_LinkOnlyDiff() {
+	testBucket.insertFileRecords(nil, []fileRecord{
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF3"},
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF4"},
+	})
+	fmt.Println(testBucket.LinkOnlyDiff(nil, "diff", testFile3, testFile4))
+	fmt.Println(testBucket.LinkOnlyDiff(nil, "diff", testFile4))
+	// Output:
+	// [TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF3 TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF4] [] <nil>
+	// [] [TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF3] <nil>
+}
+
+func ExampleBucket_LinkBatch() {
+	testBucket.insertFileRecords(nil, []fileRecord{
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"},
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2"},
+	})
+	fmt.Println(testBucket.LinkBatch(nil, map[string][]string{
+		"batch1": {testFile1, testFile2}, "batch2": {testFile1},
+	}))
+	fmt.Println(testBucket.LinkBatch(nil, map[string][]string{
+		"batch1": {testFile2}, "batch2": {testFile1}, "batch3": nil,
+	}))
+	// Output:
+	// map[batch1:{[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1 TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2] []} batch2:{[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1] []}] <nil>
+	// map[batch1:{[] [TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1]}] <nil>
+}
+
+func ExampleBucket_ObjectsOf() {
+	testBucket.insertFileRecords(nil, []fileRecord{
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF5"},
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF6"},
+	})
+	const file5, file6 = "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF5", "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF6"
+	fmt.Println(testBucket.Link(nil, "users|1|avatar", file5))
+	fmt.Println(testBucket.Link(nil, "posts|2", file5, file6))
+	fmt.Println(testBucket.Link(nil, "free-form", file5))
+	fmt.Println(testBucket.ObjectsOf(nil, file5))
+	fmt.Println(testBucket.LinkedObjects(nil, file5))
+	fmt.Println(testBucket.FilesOfObjects(nil, []string{"users|1|avatar", "posts|2", "posts|3"}))
+	// Output:
+	// <nil>
+	// <nil>
+	// <nil>
+	// [users|1|avatar posts|2] <nil>
+	// [users|1|avatar posts|2 free-form] <nil>
+	// map[posts|2:[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF5 TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF6] users|1|avatar:[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF5]] <nil>
+}
+
+func ExampleBucket_CopyLinks() {
+	testBucket.insertFileRecords(nil, []fileRecord{
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"},
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2"},
+	})
+	fmt.Println(testBucket.linkNamed(nil, "orders|1", []string{testFile1}, []string{"a.txt"}))
+	fmt.Println(testBucket.CopyLinks(nil, "orders|1", "orders|2"))
+	fmt.Println(testBucket.LinkName(nil, "orders|2", testFile1))
+	fmt.Println(testBucket.MoveLinks(nil, "orders|2", "orders|3"))
+	fmt.Println(testBucket.FilesOf(nil, "orders|2"))
+	fmt.Println(testBucket.FilesOf(nil, "orders|3"))
+	// Output:
+	// <nil>
+	// <nil>
+	// a.txt <nil>
+	// <nil>
+	// [] <nil>
+	// [TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1] <nil>
+}
+
+func ExampleBucket_MoveObjectLinks() {
+	testBucket.insertFileRecords(nil, []fileRecord{
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"},
+		{Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2"},
+	})
+	fmt.Println(testBucket.Link(nil, "products|1|cover", testFile1))
+	fmt.Println(testBucket.Link(nil, "products|1|images", testFile1, testFile2))
+	fmt.Println(testBucket.Link(nil, "products|10|cover", testFile2))
+	fmt.Println(testBucket.MoveObjectLinks(nil, LinkObject{Table: "products", ID: 1}, LinkObject{Table: "products", ID: 2}))
+	fmt.Println(testBucket.FilesOfObjects(nil, []string{
+		"products|1|cover", "products|1|images", "products|2|cover", "products|2|images", "products|10|cover",
+	}))
+	// Output:
+	// <nil>
+	// <nil>
+	// <nil>
+	// <nil>
+	// map[products|10|cover:[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2] products|2|cover:[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1] products|2|images:[TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1 TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2]] <nil>
+}
+
 func ExampleBucket_StartClean() {
 	testUpload()
 	testBucket.StartClean(time.Second, time.Nanosecond, logger.New(os.Stdout))
@@ -157,6 +246,9 @@ func getTestDB() *sql.DB {
 	if _, err := db.Exec(`
 		DROP TABLE IF EXISTS files;
 		DROP TABLE IF EXISTS file_links;
+		DROP TABLE IF EXISTS file_upload_sessions;
+		DROP TABLE IF EXISTS file_aliases;
+		DROP TABLE IF EXISTS file_accesses;
 	`); err != nil {
 		panic(err)
 	}
@@ -206,7 +298,10 @@ func ExampleGetContentHash() {
 	// Output:
 }
 
-func ExampleImageChecker() {
-	fmt.Println(imageChecker{lang: "zh"}.fileSizeError(3123456))
-	// Output: args-err: 文件大小不能超过2兆.
+func ExampleImagePolicy() {
+	fmt.Println(ImagePolicy(0).Checker("zh").Check(FileInfo{Type: "image/png", Size: 3123456}))
+	fmt.Println(ImagePolicy(0).Checker("en").Check(FileInfo{Type: "text/plain", Size: 10}))
+	// Output:
+	// args-err: 文件大小不能超过2.0 MiB.
+	// args-err: file type is not an image.
 }
@@ -0,0 +1,185 @@
+package filestorage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Purger invalidates CDN caches of files deleted by clean or Delete, it requires PathStyleURLs.
+type Purger interface {
+	// Purge is called with the urls made by PurgeURLs, every cached url under them should be purged.
+	Purge(urls []string) error
+}
+
+// PurgeFunc is an adapter to allow the use of ordinary functions as Purger.
+type PurgeFunc func(urls []string) error
+
+func (f PurgeFunc) Purge(urls []string) error {
+	return f(urls)
+}
+
+// HTTPPurger purges urls by sending a request to each of them, like the "PURGE" requests supported by
+// Varnish, Fastly and nginx with the cache purge module. The server should purge by prefix, since
+// download urls have query strings.
+type HTTPPurger struct {
+	Method string      // Defaults to "PURGE".
+	Header http.Header // Headers like authorization tokens.
+	// Appended to urls to purge by prefix, like "*" for the nginx cache purge module.
+	Wildcard string
+	Client   *http.Client
+}
+
+func (p *HTTPPurger) Purge(urls []string) error {
+	method, client := p.Method, p.Client
+	if method == "" {
+		method = "PURGE"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for _, u := range urls {
+		u += p.Wildcard
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return err
+		}
+		for key, values := range p.Header {
+			req.Header[key] = values
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// a url not cached is not an error.
+		if resp.StatusCode >= 400 && resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("purge %s: %s", u, resp.Status)
+		}
+	}
+	return nil
+}
+
+// PurgeURLs returns the path-style urls of files on every download host. Download urls of a file with
+// query strings of link objects, filenames, variants or signatures are all under them, if PathStyleURLs
+// is true, so they should be purged by prefix, or CDNs should ignore query strings in cache keys.
+func (b *Bucket) PurgeURLs(hashes ...string) []string {
+	hosts := b.downloadHosts()
+	var urls []string
+	for _, hash := range hashes {
+		for _, host := range hosts {
+			urls = append(urls, b.filePathURL(b.hostPrefix(host), hash))
+		}
+	}
+	return urls
+}
+
+func (b *Bucket) purge(hashes []string) error {
+	if b.Purger == nil || len(hashes) == 0 {
+		return nil
+	}
+	return b.Purger.Purge(b.PurgeURLs(hashes...))
+}
+
+// downloadHosts returns all hosts of download urls, an empty host means the host of DownloadURLPrefix.
+func (b *Bucket) downloadHosts() []string {
+	hosts := []string{""}
+	if len(b.DownloadHosts) > 0 {
+		hosts = nil
+	}
+	seen := make(map[string]bool)
+	add := func(list []string) {
+		for _, host := range list {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	add(b.DownloadHosts)
+	regions := make([]string, 0, len(b.RegionHosts))
+	for region := range b.RegionHosts {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		add(b.RegionHosts[region])
+	}
+	return hosts
+}
+
+// shardHost selects a host from DownloadHosts or RegionHosts of a region by the hash of key,
+// so the url of a file is always on the same host and cached once by CDNs.
+func (b *Bucket) shardHost(key, region string) string {
+	hosts := b.DownloadHosts
+	if list := b.RegionHosts[region]; region != "" && len(list) > 0 {
+		hosts = list
+	}
+	if len(hosts) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return hosts[h.Sum32()%uint32(len(hosts))]
+}
+
+// fileURL makes a url of query q on host, it's path-style if PathStyleURLs is true and q has a file.
+func (b *Bucket) fileURL(host string, q url.Values) string {
+	prefix := b.hostPrefix(host)
+	if file := q.Get("f"); b.PathStyleURLs && file != "" {
+		q = copyValues(q)
+		q.Del("b")
+		q.Del("f")
+		prefix = b.filePathURL(prefix, file)
+		if len(q) == 0 {
+			return prefix
+		}
+		return prefix + "?" + q.Encode()
+	}
+	q = copyValues(q)
+	q.Set("b", b.Name)
+	return prefix + "?" + q.Encode()
+}
+
+// hostPrefix returns DownloadURLPrefix with the host replaced by host if it's not empty.
+func (b *Bucket) hostPrefix(host string) string {
+	if host == "" {
+		return b.DownloadURLPrefix
+	}
+	u, _ := url.Parse(b.DownloadURLPrefix)
+	u.Host = host
+	return u.String()
+}
+
+// filePathURL returns the path-style url of a file without query string.
+func (b *Bucket) filePathURL(prefix, file string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + url.PathEscape(b.Name) + "/" + file
+}
+
+// DownloadQuery returns the query of a download request, the bucket and file in the path of
+// path-style urls like "/files/bucket/hash" are set as the "b" and "f" parameters.
+func DownloadQuery(req *http.Request) url.Values {
+	q := req.URL.Query()
+	if q.Get("f") == "" {
+		dir, file := path.Split(req.URL.Path)
+		if IsHash(file) {
+			q.Set("f", file)
+			if bucket, err := url.PathUnescape(path.Base(dir)); err == nil && q.Get("b") == "" {
+				q.Set("b", bucket)
+			}
+		}
+	}
+	return q
+}
+
+func copyValues(q url.Values) url.Values {
+	result := make(url.Values, len(q))
+	for k, v := range q {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,54 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+)
+
+func ExampleBucket_PurgeURLs() {
+	bucket := Bucket{
+		Name: "cdn", DownloadURLPrefix: "https://example.com/files", PathStyleURLs: true,
+		DownloadHosts: []string{"cdn1.example.com", "cdn2.example.com"},
+		RegionHosts:   map[string][]string{"eu": {"eu.example.com"}},
+	}
+	hash := "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"
+	fmt.Println(bucket.DownloadURL("o", hash))
+	fmt.Println(bucket.DownloadURLWithOptions("o", hash, URLOptions{Region: "eu"}))
+	for _, u := range bucket.PurgeURLs(hash) {
+		fmt.Println(u)
+	}
+	// Output:
+	// https://cdn1.example.com/files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1?o=o
+	// https://eu.example.com/files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1?o=o
+	// https://cdn1.example.com/files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1
+	// https://cdn2.example.com/files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1
+	// https://eu.example.com/files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1
+}
+
+func ExampleHTTPPurger() {
+	var purged []string
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		purged = append(purged, req.Method+" "+req.URL.Path+" "+req.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	bucket := Bucket{
+		Name: "cdn", DownloadURLPrefix: server.URL + "/files", PathStyleURLs: true,
+		Purger: &HTTPPurger{Header: http.Header{"Authorization": {"token"}}, Wildcard: "*"},
+	}
+	fmt.Println(bucket.purge([]string{"TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"}))
+	sort.Strings(purged)
+	fmt.Println(purged)
+	// Output:
+	// <nil>
+	// [PURGE /files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1* token]
+}
+
+func ExampleDownloadQuery() {
+	req := httptest.NewRequest("GET", "/files/cdn/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1?o=o", nil)
+	fmt.Println(DownloadQuery(req).Encode())
+	// Output:
+	// b=cdn&f=TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1&o=o
+}
@@ -0,0 +1,304 @@
+package filestorage
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/lovego/errs"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// FileInfo is the information of a file to check.
+type FileInfo struct {
+	Name string // The original file name, maybe empty.
+	Type string // The content type detected from file content.
+	Size int64
+	IO   io.ReadSeeker // The file content, it's seeked to start before and after checking.
+}
+
+// FileChecker checks if a file is allowed to store.
+type FileChecker interface {
+	Check(file FileInfo) error
+}
+
+// CheckFunc adapts an ordinary function that checks content type and size to a FileChecker.
+type CheckFunc func(contentType string, size int64) error
+
+func (f CheckFunc) Check(file FileInfo) error {
+	return f(file.Type, file.Size)
+}
+
+// funcChecker returns a FileChecker of fileCheck, or nil if fileCheck is nil.
+func funcChecker(fileCheck func(string, int64) error) FileChecker {
+	if fileCheck == nil {
+		return nil
+	}
+	return CheckFunc(fileCheck)
+}
+
+// Rule is a composable file check rule, lang is used to localize the error message.
+type Rule interface {
+	Check(lang string, file FileInfo) error
+}
+
+// Policy is a Rule composed of rules, a file must pass all of them.
+type Policy []Rule
+
+func (p Policy) Check(lang string, file FileInfo) error {
+	for _, rule := range p {
+		if err := rule.Check(lang, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MaxSize returns the minimum positive Max of all Size rules, or 0 if there is no such rule.
+func (p Policy) MaxSize() int64 {
+	var max int64
+	for _, rule := range p {
+		var m int64
+		switch r := rule.(type) {
+		case Size:
+			m = r.Max
+		case Policy:
+			m = r.MaxSize()
+		}
+		if m > 0 && (max == 0 || m < max) {
+			max = m
+		}
+	}
+	return max
+}
+
+// Checker returns a FileChecker that checks files by the policy and localize errors by lang.
+func (p Policy) Checker(lang string) FileChecker {
+	return policyChecker{policy: p, lang: lang}
+}
+
+type policyChecker struct {
+	policy Policy
+	lang   string
+}
+
+func (c policyChecker) Check(file FileInfo) error {
+	if file.IO != nil {
+		if _, err := file.IO.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		defer file.IO.Seek(0, io.SeekStart)
+	}
+	return c.policy.Check(c.lang, file)
+}
+
+func (c policyChecker) MaxSize() int64 {
+	return c.policy.MaxSize()
+}
+
+// check checks a file by checker if checker is not nil.
+func check(checker FileChecker, file FileInfo) error {
+	if checker == nil {
+		return nil
+	}
+	return checker.Check(file)
+}
+
+// checkerLang returns the language to localize errors of a checker.
+func checkerLang(checker FileChecker) string {
+	if c, ok := checker.(policyChecker); ok {
+		return c.lang
+	}
+	return ""
+}
+
+// ImagePolicy allows only images not larger than maxSize, if maxSize <= 0, 2MiB is used.
+func ImagePolicy(maxSize int64) Policy {
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	return Policy{
+		MimeTypes{Allow: []string{"image/*"}, ZhMessage: "文件类型不是图片.", EnMessage: "file type is not an image."},
+		Size{Max: maxSize},
+	}
+}
+
+var errUnknownPolicy = errs.New("args-err", "unknown policy")
+
+// Checker returns a FileChecker of the named policy registered in Policies.
+func (b *Bucket) Checker(policy, lang string) (FileChecker, error) {
+	if p, ok := b.Policies[policy]; ok {
+		return p.Checker(lang), nil
+	}
+	return nil, errUnknownPolicy
+}
+
+// uploadChecker returns the checker of the named policy, or ImagePolicy(maxSize) if policy is empty.
+func (b *Bucket) uploadChecker(policy, lang string, maxSize int64) (FileChecker, error) {
+	if policy == "" {
+		return ImagePolicy(maxSize).Checker(lang), nil
+	}
+	return b.Checker(policy, lang)
+}
+
+// MimeTypes checks file content type by allow and deny lists.
+// Types in lists can be wildcards like "image/*" or "*/*".
+type MimeTypes struct {
+	Allow []string // If not empty, only these types are allowed.
+	Deny  []string
+	// The error messages of types not allowed, default to "文件类型不允许." and "file type is not allowed.".
+	ZhMessage, EnMessage string
+}
+
+func (m MimeTypes) Check(lang string, file FileInfo) error {
+	if len(m.Allow) > 0 && !matchMimeType(m.Allow, file.Type) || matchMimeType(m.Deny, file.Type) {
+		zh, en := m.ZhMessage, m.EnMessage
+		if zh == "" {
+			zh = "文件类型不允许."
+		}
+		if en == "" {
+			en = "file type is not allowed."
+		}
+		return localize(lang, zh, en).SetData(file.Type)
+	}
+	return nil
+}
+
+func matchMimeType(patterns []string, contentType string) bool {
+	typ := baseMimeType(contentType)
+	for _, pattern := range patterns {
+		if pattern == "*/*" || pattern == "*" || pattern == typ ||
+			strings.HasSuffix(pattern, "/*") && strings.HasPrefix(typ, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseMimeType returns the mime type without parameters.
+func baseMimeType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// ExtensionMatch checks if file name extension is consistent with file content type.
+// Files without name, or with an unknown extension are passed.
+type ExtensionMatch struct{}
+
+func (ExtensionMatch) Check(lang string, file FileInfo) error {
+	ext := strings.ToLower(filepath.Ext(file.Name))
+	if ext == "" {
+		return nil
+	}
+	extType := baseMimeType(TypeByExtension(ext))
+	if extType == "" || compatibleMimeType(baseMimeType(file.Type), extType) {
+		return nil
+	}
+	return localize(
+		lang, "文件扩展名与文件内容不一致.", "file extension doesn't match file content.",
+	).SetData(file.Type)
+}
+
+// compatibleMimeType checks if detected type is compatible with the type implied by extension.
+// Active types like html, javascript and svg are never compatible with generic text types,
+// because they are always detected from file content.
+func compatibleMimeType(detected, implied string) bool {
+	if detected == implied {
+		return true
+	}
+	switch detected {
+	case "text/plain":
+		return strings.HasPrefix(implied, "text/") &&
+			implied != "text/html" && implied != "text/javascript"
+	case "text/xml":
+		return strings.HasSuffix(implied, "+xml") && implied != "image/svg+xml" ||
+			implied == "application/xml"
+	case "application/zip":
+		return strings.HasSuffix(implied, "+zip") ||
+			strings.HasPrefix(implied, "application/vnd.openxmlformats-") ||
+			strings.HasPrefix(implied, "application/vnd.oasis.opendocument.")
+	}
+	return false
+}
+
+// Size checks file size, zero Min or Max means no limit.
+type Size struct {
+	Min, Max int64
+}
+
+func (s Size) Check(lang string, file FileInfo) error {
+	if s.Max > 0 && file.Size > s.Max {
+		return fileSizeError(lang, s.Max, file.Size)
+	}
+	if s.Min > 0 && file.Size < s.Min {
+		msg := humanize.IBytes(uint64(s.Min))
+		return localizef(lang, "文件大小不能小于%s.", "file size cann't be less than %s.", msg).
+			SetData(printer.Sprintf("%d", file.Size))
+	}
+	return nil
+}
+
+// ImageDimensions checks image width and height, zero limits means no limit.
+// Only images of decodable formats are checked, other files are passed.
+type ImageDimensions struct {
+	MinWidth, MinHeight int
+	MaxWidth, MaxHeight int
+}
+
+func (d ImageDimensions) Check(lang string, file FileInfo) error {
+	if file.IO == nil || !strings.HasPrefix(file.Type, "image/") {
+		return nil
+	}
+	config, _, err := image.DecodeConfig(file.IO)
+	if err == image.ErrFormat {
+		return nil
+	} else if err != nil {
+		return localize(lang, "无法识别的图片.", "unrecognized image.").SetData(file.Type)
+	}
+	data := []int{config.Width, config.Height}
+	switch {
+	case d.MaxWidth > 0 && config.Width > d.MaxWidth:
+		return localizef(lang, "图片宽度不能超过%d.", "image width cann't exceed %d.", d.MaxWidth).SetData(data)
+	case d.MaxHeight > 0 && config.Height > d.MaxHeight:
+		return localizef(lang, "图片高度不能超过%d.", "image height cann't exceed %d.", d.MaxHeight).SetData(data)
+	case config.Width < d.MinWidth:
+		return localizef(lang, "图片宽度不能小于%d.", "image width cann't be less than %d.", d.MinWidth).SetData(data)
+	case config.Height < d.MinHeight:
+		return localizef(lang, "图片高度不能小于%d.", "image height cann't be less than %d.", d.MinHeight).SetData(data)
+	}
+	return nil
+}
+
+var printer = message.NewPrinter(language.English)
+
+func fileSizeError(lang string, maxSize, size int64) error {
+	msg := humanize.IBytes(uint64(maxSize))
+	return localizef(lang, "文件大小不能超过%s.", "file size cann't exceed %s.", msg).
+		SetData(printer.Sprintf("%d", size))
+}
+
+func localize(lang, zh, en string) *errs.Error {
+	switch lang {
+	case "zh", "cn":
+		return errs.New("args-err", zh)
+	default:
+		return errs.New("args-err", en)
+	}
+}
+
+func localizef(lang, zh, en string, args ...interface{}) *errs.Error {
+	switch lang {
+	case "zh", "cn":
+		return errs.Newf("args-err", zh, args...)
+	default:
+		return errs.Newf("args-err", en, args...)
+	}
+}
@@ -0,0 +1,42 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+)
+
+func ExamplePolicy_Checker() {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		panic(err)
+	}
+	policy := Policy{
+		MimeTypes{Allow: []string{"image/*", "text/plain"}, Deny: []string{"image/gif"}},
+		ExtensionMatch{},
+		Size{Min: 1, Max: 1000},
+		ImageDimensions{MaxWidth: 20},
+	}
+	checker := policy.Checker("en")
+	fmt.Println(checker.Check(FileInfo{Name: "a.gif", Type: "image/gif", Size: 10}))
+	fmt.Println(checker.Check(FileInfo{Name: "a.jpg", Type: "image/png", Size: 10}))
+	fmt.Println(checker.Check(FileInfo{Name: "a.csv", Type: "text/plain; charset=utf-8", Size: 10}))
+	fmt.Println(checker.Check(FileInfo{Name: "a.txt", Type: "text/plain; charset=utf-8", Size: 0}))
+	fmt.Println(checker.Check(FileInfo{Name: "a.png", Type: "image/png", Size: 2000}))
+	fmt.Println(checker.Check(FileInfo{
+		Name: "a.png", Type: "image/png", Size: int64(buf.Len()), IO: bytes.NewReader(buf.Bytes()),
+	}))
+	// Output:
+	// args-err: file type is not allowed.
+	// args-err: file extension doesn't match file content.
+	// <nil>
+	// args-err: file size cann't be less than 1 B.
+	// args-err: file size cann't exceed 1000 B.
+	// args-err: image width cann't exceed 20.
+}
+
+func ExamplePolicy_MaxSize() {
+	fmt.Println(Policy{Size{Max: 1000}, ImagePolicy(500)}.MaxSize())
+	// Output: 500
+}
@@ -32,9 +32,10 @@ func (b *Bucket) StartClean(cleanInterval, cleanAfter time.Duration, logger Logg
 }
 
 func (b *Bucket) clean(cleanAfter time.Duration) error {
-	return runInTx(b.DB, func(tx DB) error {
-		files, err := b.cleanDB(tx, cleanAfter)
-		if err != nil {
+	var files []string
+	if err := runInTx(b.DB, func(tx DB) error {
+		var err error
+		if files, err = b.cleanDB(tx, cleanAfter); err != nil {
 			return err
 		}
 		for _, file := range files {
@@ -43,32 +44,104 @@ func (b *Bucket) clean(cleanAfter time.Duration) error {
 			}
 		}
 
+		return b.cleanUploads(tx)
+	}); err != nil {
+		return err
+	}
+	return b.purge(files)
+}
+
+// Delete deletes files even if they are linked, their links, aliases and image variants are also deleted,
+// except variants linked in their own right, and their CDN caches are purged by Purger.
+func (b *Bucket) Delete(db DB, hashes ...string) error {
+	if len(hashes) == 0 {
 		return nil
-	})
+	}
+	if err := CheckHash(hashes...); err != nil {
+		return err
+	}
+	hashes, err := b.resolveAliases(db, hashes)
+	if err != nil {
+		return err
+	}
+	var files []string
+	if err := runInTx(b.getDB(db), func(tx DB) error {
+		var err error
+		if files, err = b.queryFiles(tx, fmt.Sprintf(
+			`DELETE FROM %s WHERE hash IN (%s) RETURNING hash`, b.FilesTable, quoteList(hashes),
+		)); err != nil || len(files) == 0 {
+			return err
+		}
+		// variants linked in their own right are kept, like cleanDB.
+		for deleted := files; len(deleted) > 0; {
+			if deleted, err = b.queryFiles(tx, fmt.Sprintf(`
+			DELETE FROM %s f
+			WHERE parent IN (%s) AND NOT EXISTS (
+			  SELECT 1 FROM %s WHERE file = f.hash
+			)
+			RETURNING hash
+			`, b.FilesTable, quoteList(deleted), b.LinksTable,
+			)); err != nil {
+				return err
+			}
+			files = append(files, deleted...)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE file IN (%s);
+		DELETE FROM %s WHERE hash IN (%s);
+		DELETE FROM %s WHERE file IN (%s);
+		`, b.LinksTable, quoteList(files), b.AliasesTable, quoteList(files), b.AccessesTable, quoteList(files),
+		)); err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := b.deleteFile(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return b.purge(files)
 }
 
 func (b *Bucket) cleanDB(tx DB, cleanAfter time.Duration) ([]string, error) {
-	sql := fmt.Sprintf(`
-	DELETE FROM %s
+	files, err := b.queryFiles(tx, fmt.Sprintf(`
+	DELETE FROM %s f
 	WHERE NOT EXISTS (
-	  SELECT 1 FROM %s WHERE file = hash
-	) AND created_at < %s
-	RETURNING hash
-	`, b.FilesTable, b.LinksTable, fmtTime(time.Now().Add(-cleanAfter)),
+	  SELECT 1 FROM %s WHERE file = f.hash
+	) AND created_at < %s AND (
+	  parent = '' OR NOT EXISTS (SELECT 1 FROM %s WHERE hash = f.parent)
 	)
-	rows, err := tx.Query(sql)
+	RETURNING hash
+	`, b.FilesTable, b.LinksTable, fmtTime(time.Now().Add(-cleanAfter)), b.FilesTable,
+	))
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var files []string
-	for rows.Next() {
-		var file string
-		if err := rows.Scan(&file); err != nil {
+	// image variants are deleted along with their parent, variants of variants are deleted in turn.
+	for deleted := files; len(deleted) > 0; {
+		if deleted, err = b.queryFiles(tx, fmt.Sprintf(`
+		DELETE FROM %s f
+		WHERE parent IN (%s) AND NOT EXISTS (
+		  SELECT 1 FROM %s WHERE file = f.hash
+		)
+		RETURNING hash
+		`, b.FilesTable, quoteList(deleted), b.LinksTable,
+		)); err != nil {
+			return nil, err
+		}
+		files = append(files, deleted...)
+	}
+	if len(files) > 0 {
+		if _, err := tx.Exec(fmt.Sprintf(`
+		DELETE FROM %s WHERE hash IN (%s);
+		DELETE FROM %s WHERE file IN (%s);
+		`, b.AliasesTable, quoteList(files), b.AccessesTable, quoteList(files),
+		)); err != nil {
 			return nil, err
 		}
-		files = append(files, file)
 	}
 	return files, nil
 }
@@ -80,7 +153,11 @@ func (b *Bucket) deleteFile(hash string) error {
 		`cd %s; test -f %s && rm -f %s && rmdir -p --ignore-fail-on-non-empty %s || true`,
 		b.Dir, path, path, dir,
 	)
+	return b.runScript(script)
+}
 
+// runScript runs a bash script on every machine of the bucket.
+func (b *Bucket) runScript(script string) error {
 	if b.localMachine {
 		var cmd = exec.Command("bash", "-c", script)
 		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
@@ -0,0 +1,217 @@
+package filestorage
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Delivery sends the content of a file in download responses.
+// Content-Type and the security headers are set before Deliver is called, and the caching and
+// disposition headers are written by DeliveryFile.WriteHeader, so a delivery can decide to leave them
+// to the web server, but DeliveryFile.WriteRequiredHeader must be called then.
+// Files missing on this machine are always sent by DirectSend.
+type Delivery interface {
+	// Deliver sends a file, req is nil if the download is not from a request.
+	Deliver(resp http.ResponseWriter, req *http.Request, file *DeliveryFile) error
+}
+
+// DeliveryFile is a file to deliver.
+type DeliveryFile struct {
+	Hash string
+	Dir  string // Bucket.Dir
+	Path string // The path relative to Dir.
+
+	ETag         string
+	CacheControl string
+	Disposition  string // The Content-Disposition header.
+	// If true, Disposition is an attachment forced for active content like html and svg,
+	// so it's always sent, even if other headers are left to the web server.
+	ForcedDisposition bool
+
+	bucket *Bucket
+}
+
+// FullPath returns the path of the file on disk.
+func (f *DeliveryFile) FullPath() string {
+	return filepath.Join(f.Dir, f.Path)
+}
+
+// WriteHeader writes the caching and disposition headers.
+func (f *DeliveryFile) WriteHeader(resp http.ResponseWriter) {
+	header := resp.Header()
+	if f.ETag != "" {
+		header.Set("ETag", f.ETag)
+	}
+	if f.CacheControl != "" {
+		header.Set("Cache-Control", f.CacheControl)
+	}
+	if f.Disposition != "" {
+		header.Set("Content-Disposition", f.Disposition)
+	}
+}
+
+// WriteRequiredHeader writes the headers which must be sent even if other headers are left to the web
+// server: the "X-Content-Type-Options: nosniff" header, and the disposition if it's forced.
+func (f *DeliveryFile) WriteRequiredHeader(resp http.ResponseWriter) {
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	if f.ForcedDisposition && f.Disposition != "" {
+		resp.Header().Set("Content-Disposition", f.Disposition)
+	}
+}
+
+// Open opens the file, it's read from other machines if it's missing on this machine.
+func (f *DeliveryFile) Open() (io.ReadCloser, error) {
+	if f.bucket == nil {
+		return os.Open(f.FullPath())
+	}
+	return f.bucket.openFile(f.Hash)
+}
+
+// notModified responds 304 if the request has a matched If-None-Match header.
+func (f *DeliveryFile) notModified(resp http.ResponseWriter, req *http.Request) bool {
+	if req != nil && etagMatch(req.Header.Get("If-None-Match"), f.ETag) {
+		resp.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// DirectSend sends files in the response body, range, conditional and HEAD requests are supported.
+// The bandwidth and concurrency are limited by RateLimit, ClientRateLimit and MaxDownloads of the bucket.
+type DirectSend struct{}
+
+func (DirectSend) Deliver(resp http.ResponseWriter, req *http.Request, file *DeliveryFile) error {
+	r, err := file.Open()
+	if err != nil {
+		if os.IsNotExist(err) {
+			resp.WriteHeader(http.StatusNotFound)
+			return nil
+		}
+		return err
+	}
+	defer r.Close()
+
+	if file.bucket != nil {
+		w, release, err := file.bucket.throttled(resp, req)
+		if err != nil {
+			return err
+		}
+		defer release()
+		resp = w
+	}
+	// written after the file is opened and a download slot is taken, so error responses are not cached.
+	file.WriteHeader(resp)
+	f, isLocal := r.(*os.File)
+	if req != nil {
+		if isLocal {
+			// files never change, so the ETag is enough, and Last-Modified is not sent.
+			http.ServeContent(resp, req, "", time.Time{}, f)
+			return nil
+		}
+		// a file streamed from other machines is not seekable, so range requests are responded in full.
+		if file.notModified(resp, req) || req.Method == http.MethodHead {
+			return nil
+		}
+	}
+	if isLocal {
+		if info, err := f.Stat(); err == nil {
+			resp.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		}
+	}
+	_, err = io.Copy(resp, r)
+	return err
+}
+
+/*
+XAccel lets nginx send files by the "X-Accel-Redirect" header, a location like following is required
+in nginx virtual server config.
+
+	location /fs/ {
+	  internal;
+	  alias /data/file-storage;
+	}
+
+The location prefix and alias path should be set according to PathPrefix and Bucket.Dir.
+*/
+type XAccel struct {
+	PathPrefix string
+	// The "X-Accel-Buffering" header, "yes" or "no", not sent if empty.
+	Buffering string
+	// The "X-Accel-Limit-Rate" header in bytes per second, defaults to Bucket.ClientRateLimit.
+	LimitRate int64
+	// If true, the caching and disposition headers are left to nginx config, except required headers,
+	// see DeliveryFile.WriteRequiredHeader.
+	OmitHeaders bool
+}
+
+func (x *XAccel) Deliver(resp http.ResponseWriter, req *http.Request, file *DeliveryFile) error {
+	if x.OmitHeaders {
+		file.WriteRequiredHeader(resp)
+	} else {
+		file.WriteHeader(resp)
+	}
+	// nginx handles range requests, but the ETag it makes is based on the modification time.
+	if file.notModified(resp, req) {
+		return nil
+	}
+	prefix := x.PathPrefix
+	if prefix == "" || prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	header := resp.Header()
+	header.Set("X-Accel-Redirect", path.Join(prefix, filepath.ToSlash(file.Path)))
+	if x.Buffering != "" {
+		header.Set("X-Accel-Buffering", x.Buffering)
+	}
+	limitRate := x.LimitRate
+	if limitRate == 0 && file.bucket != nil {
+		limitRate = file.bucket.ClientRateLimit
+	}
+	if limitRate > 0 {
+		header.Set("X-Accel-Limit-Rate", strconv.FormatInt(limitRate, 10))
+	}
+	return nil
+}
+
+// XSendfile lets Apache(mod_xsendfile) or lighttpd send files by a header with the full path of files.
+// It also works with Caddy by a "handle_response" block matching the header.
+type XSendfile struct {
+	// The header name, defaults to "X-Sendfile", and "X-LIGHTTPD-send-file" for old lighttpd.
+	Header string
+	// If true, the caching and disposition headers are left to the web server config, except required headers,
+	// see DeliveryFile.WriteRequiredHeader.
+	OmitHeaders bool
+}
+
+func (x *XSendfile) Deliver(resp http.ResponseWriter, req *http.Request, file *DeliveryFile) error {
+	if x.OmitHeaders {
+		file.WriteRequiredHeader(resp)
+	} else {
+		file.WriteHeader(resp)
+	}
+	if file.notModified(resp, req) {
+		return nil
+	}
+	name := x.Header
+	if name == "" {
+		name = "X-Sendfile"
+	}
+	resp.Header().Set(name, file.FullPath())
+	return nil
+}
+
+// delivery returns Delivery, or XAccel if RedirectPathPrefix is present, or DirectSend.
+func (b *Bucket) delivery() Delivery {
+	if b.Delivery != nil {
+		return b.Delivery
+	}
+	if b.RedirectPathPrefix != "" {
+		return &XAccel{PathPrefix: b.RedirectPathPrefix}
+	}
+	return DirectSend{}
+}
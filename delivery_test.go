@@ -0,0 +1,39 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http/httptest"
+)
+
+func ExampleXAccel() {
+	file := &DeliveryFile{
+		Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1", Dir: "/data/files",
+		Path: "T/E/a/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1", ETag: `"TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"`,
+		Disposition: `attachment; filename="a.txt"`,
+	}
+	resp := httptest.NewRecorder()
+	delivery := &XAccel{PathPrefix: "/fs", Buffering: "no", LimitRate: 1 << 20}
+	fmt.Println(delivery.Deliver(resp, httptest.NewRequest("GET", "/", nil), file))
+	fmt.Println(resp.Header().Get("X-Accel-Redirect"))
+	fmt.Println(resp.Header().Get("X-Accel-Buffering"), resp.Header().Get("X-Accel-Limit-Rate"))
+	fmt.Println(resp.Header().Get("Content-Disposition"))
+
+	resp = httptest.NewRecorder()
+	fmt.Println((&XSendfile{OmitHeaders: true}).Deliver(resp, nil, file))
+	fmt.Println(resp.Header().Get("X-Sendfile"), resp.Header().Get("Content-Disposition") == "")
+
+	// a forced attachment of active content is always sent.
+	file.ForcedDisposition = true
+	resp = httptest.NewRecorder()
+	fmt.Println((&XAccel{PathPrefix: "/fs", OmitHeaders: true}).Deliver(resp, nil, file))
+	fmt.Println(resp.Header().Get("Content-Disposition"), resp.Header().Get("X-Content-Type-Options"))
+	// Output:
+	// <nil>
+	// /fs/T/E/a/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1
+	// no 1048576
+	// attachment; filename="a.txt"
+	// <nil>
+	// /data/files/T/E/a/TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1 true
+	// <nil>
+	// attachment; filename="a.txt" nosniff
+}
@@ -0,0 +1,99 @@
+package filestorage
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DispositionInline     = "inline"     // Display the file in browsers if possible.
+	DispositionAttachment = "attachment" // Save the file by browsers.
+)
+
+/*
+ContentDisposition makes a Content-Disposition header value by RFC 6266,
+mode is DispositionInline or DispositionAttachment, other values are treated as attachment.
+Non-ASCII filenames are encoded in the "filename*" parameter by RFC 5987, and an ASCII fallback
+is provided in the "filename" parameter for old browsers, like:
+
+	attachment; filename="_.pdf"; filename*=UTF-8''%E5%90%88%E5%90%8C.pdf
+*/
+func ContentDisposition(mode, filename string) string {
+	if mode != DispositionInline {
+		mode = DispositionAttachment
+	}
+	filename = cleanFilename(filename)
+	if filename == "" {
+		return mode
+	}
+	fallback := asciiFilename(filename)
+	if fallback == filename {
+		return mode + `; filename="` + filename + `"`
+	}
+	return mode + `; filename="` + fallback + `"; filename*=UTF-8''` + encodeRFC5987(filename)
+}
+
+// asciiFilename replaces non-ASCII characters and characters need quoting with "_".
+// Successive non-ASCII characters are replaced by one "_", and the extension is kept if possible.
+func asciiFilename(filename string) string {
+	var b strings.Builder
+	var replaced bool
+	for _, r := range filename {
+		if r < utf8.RuneSelf && r >= ' ' && r != '"' && r != '\\' && r != '%' {
+			b.WriteRune(r)
+			replaced = false
+		} else if !replaced {
+			b.WriteByte('_')
+			replaced = true
+		}
+	}
+	return b.String()
+}
+
+// encodeRFC5987 percent-encodes all bytes except the "attr-char" of RFC 5987.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		}
+	}
+	return b.String()
+}
+
+// cleanFilename removes directories and control characters from a file name.
+func cleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// variantFilename changes the extension of a file name to the variant format.
+func variantFilename(name, format string) string {
+	if name == "" || format == "" {
+		return name
+	}
+	if format == "jpeg" {
+		format = "jpg"
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+}
@@ -0,0 +1,32 @@
+package filestorage
+
+import (
+	"fmt"
+	"strings"
+)
+
+func ExampleContentDisposition() {
+	fmt.Println(ContentDisposition(DispositionInline, "report.pdf"))
+	fmt.Println(ContentDisposition(DispositionAttachment, "合同.pdf"))
+	// Output:
+	// inline; filename="report.pdf"
+	// attachment; filename="_.pdf"; filename*=UTF-8''%E5%90%88%E5%90%8C.pdf
+}
+
+func ExampleBucket_SetLinkName() {
+	hashes, err := testBucket.Save(nil, nil, "named", File{
+		IO: strings.NewReader("contract"), Name: "C:\\docs\\合同.txt", Size: 8,
+	})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(testBucket.LinkName(nil, "named", hashes[0]))
+	fmt.Println(testBucket.SetLinkName(nil, "named", hashes[0], "contract.txt"))
+	fmt.Println(testBucket.LinkName(nil, "named", hashes[0]))
+	fmt.Println(testBucket.SetLinkName(nil, "unnamed", hashes[0], "contract.txt"))
+	// Output:
+	// 合同.txt <nil>
+	// <nil>
+	// contract.txt <nil>
+	// args-err: the file is not linked to the object
+}
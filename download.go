@@ -3,13 +3,13 @@ package filestorage
 import (
 	"database/sql"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/lovego/errs"
 )
@@ -17,21 +17,66 @@ import (
 // DownloadURL make the url for file download
 // hosts support specified download url host
 func (b *Bucket) DownloadURL(linkObject interface{}, fileHash string, hosts ...string) string {
+	var options URLOptions
+	if len(hosts) > 0 {
+		options.Host = hosts[0]
+	}
+	return b.DownloadURLWithOptions(linkObject, fileHash, options)
+}
+
+// URLOptions are options to make download urls.
+type URLOptions struct {
+	Host    string  // Use the specified host instead of the host of DownloadURLPrefix or DownloadHosts.
+	Region  string  // Select the host from RegionHosts of the region.
+	Variant Variant // Download an image variant instead of the original file.
+	// The filename to save as, defaults to the name stored when the file is linked to the object.
+	Filename string
+	// DispositionInline or DispositionAttachment, defaults to DispositionInline.
+	// Active content like html is always downloaded as an attachment.
+	Disposition string
+
+	// The following options are used only if download urls are signed(DownloadURLExpiry > 0).
+	Expiry time.Duration // Overrides DownloadURLExpiry of the bucket if > 0.
+	// If not empty, the url is valid only for requests from this ip, see ClientIP.
+	ClientIP string
+	// If not empty, the url is valid only for requests of this user, see UserOf.
+	User string
+}
+
+// DownloadURLWithOptions make the url for file download with options.
+func (b *Bucket) DownloadURLWithOptions(linkObject interface{}, fileHash string, options URLOptions) string {
 	if fileHash == "" || !IsHash(fileHash) {
 		return fileHash
 	}
 	q := url.Values{}
-	q.Set("b", b.Name)   // bucket
 	q.Set("f", fileHash) // file
+	options.Variant.setQuery(q)
+	return b.downloadURL(q, linkObject, options)
+}
+
+func (b *Bucket) downloadURL(q url.Values, linkObject interface{}, options URLOptions) string {
+	q.Set("b", b.Name) // bucket
 	if linkObject != nil {
 		q.Set("o", fmt.Sprint(linkObject)) // link object
 	}
-	if len(hosts) > 0 && hosts[0] != "" {
-		u, _ := url.Parse(b.DownloadURLPrefix)
-		u.Host = hosts[0]
-		return u.String() + "?" + q.Encode()
+	if options.Filename != "" {
+		q.Set("filename", options.Filename)
+	}
+	if options.Disposition != "" {
+		q.Set("disposition", options.Disposition)
+	}
+	if b.DownloadURLExpiry > 0 {
+		b.signDownloadQuery(q, options)
 	}
-	return b.DownloadURLPrefix + "?" + q.Encode()
+	host := options.Host
+	if host == "" {
+		key := q.Get("f")
+		if key == "" {
+			key = q.Get("o")
+		}
+		host = b.shardHost(key, options.Region)
+	}
+	return b.fileURL(host, q)
 }
 
 // DownloadURLs make the urls for files download
@@ -44,71 +89,219 @@ func (b *Bucket) DownloadURLs(linkObject interface{}, fileHashes []string, hosts
 	return urls
 }
 
+// DownloadURLsWithOptions make the urls for files download with options.
+func (b *Bucket) DownloadURLsWithOptions(
+	linkObject interface{}, fileHashes []string, options URLOptions,
+) []string {
+	urls := make([]string, len(fileHashes))
+	for i, hash := range fileHashes {
+		urls[i] = b.DownloadURLWithOptions(linkObject, hash, options)
+	}
+	return urls
+}
+
 // Download file according to the requested bucket, file, link object
 func Download(req *http.Request, resp http.ResponseWriter) error {
-	q := req.URL.Query()
-	bucket, err := GetBucket(q.Get("b"))
+	bucket, err := GetBucket(DownloadQuery(req).Get("b"))
 	if err != nil {
 		return err
 	}
-	return bucket.Download(nil, resp, q.Get("f"), q.Get("o"))
+	return bucket.ServeDownload(nil, req, resp)
 }
 
-/*
-Download file, if object is not empty, the file must be linked to it, otherwise an error is returned.
-If RedirectPathPrefix is not empty, an location like following is required in nginx virtual server config.
-	location /fs/ {
-	  internal;
-	  alias /data/file-storage;
+// ServeDownload download the file requested by a url made by DownloadURL,
+// the url signature is verified, and the request is authorized by Authorizer.
+// Image variants are served only if they are in Variants or the url is signed.
+// Range, conditional(If-None-Match) and HEAD requests are supported.
+// Zip archives requested by urls made by DownloadZipURL are served by DownloadZip,
+// only if Authorizer is present or download urls are signed.
+func (b *Bucket) ServeDownload(db DB, req *http.Request, resp http.ResponseWriter) error {
+	q := DownloadQuery(req)
+	if err := b.VerifyDownloadURL(req); err != nil {
+		resp.WriteHeader(http.StatusForbidden)
+		return err
 	}
-The location prefix and alias path should be set according to RedirectPathPrefix and Dir.
-*/
+	if err := b.Authorize(req, q.Get("o"), OpDownload); err != nil {
+		if IsForbidden(err) {
+			resp.WriteHeader(http.StatusForbidden)
+		} else if err == errInvalidObject {
+			resp.WriteHeader(http.StatusBadRequest)
+		}
+		return err
+	}
+	if q.Get("zip") == "1" {
+		if !b.canListObject() {
+			resp.WriteHeader(http.StatusForbidden)
+			return errForbidden
+		}
+		w, release, err := b.throttled(resp, req)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return b.DownloadZip(db, w, q.Get("o"), q.Get("filename"))
+	}
+	variant, err := ParseVariant(q)
+	if err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return err
+	}
+	if !b.variantAllowed(variant) {
+		resp.WriteHeader(http.StatusForbidden)
+		return errVariantNotAllowed
+	}
+	return b.download(db, req, resp, q.Get("f"), q.Get("o"), downloadOptions{
+		Variant: variant, Filename: q.Get("filename"), Disposition: q.Get("disposition"),
+	})
+}
+
+// Download file, if object is not empty, the file must be linked to it, otherwise an error is returned.
+// The file is sent by Delivery, see XAccel for the nginx config if RedirectPathPrefix is not empty.
 func (b *Bucket) Download(db DB, resp http.ResponseWriter, file string, object string) error {
+	return b.DownloadVariant(db, resp, file, object, Variant{})
+}
+
+// DownloadVariant download an image variant of file, it's generated on first download.
+// If variant is zero, the original file is downloaded.
+// Variants of files missing locally are generated from the copies on other machines.
+func (b *Bucket) DownloadVariant(db DB, resp http.ResponseWriter, file, object string, variant Variant) error {
+	return b.download(db, nil, resp, file, object, downloadOptions{Variant: variant})
+}
+
+type downloadOptions struct {
+	Variant     Variant
+	Filename    string
+	Disposition string
+}
+
+// download file, if req is not nil, range and conditional requests are handled.
+func (b *Bucket) download(
+	db DB, req *http.Request, resp http.ResponseWriter, file, object string, options downloadOptions,
+) error {
 	if err := CheckHash(file); err != nil {
 		resp.WriteHeader(http.StatusBadRequest)
 		return err
 	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return err
+	}
+	var filename = options.Filename
 	if object != "" {
-		if err := b.EnsureLinked(db, object, file); err != nil {
-			resp.WriteHeader(http.StatusBadRequest)
+		name, err := b.linkName(db, object, file)
+		if err != nil {
+			if err == errNotLinked {
+				resp.WriteHeader(http.StatusBadRequest)
+			}
 			return err
 		}
+		if filename == "" {
+			filename = name
+		}
 	}
-	if err := b.writeHeader(db, resp, file); err != nil {
+	if variant := options.Variant; !variant.IsZero() {
+		if err := b.ensureNotInfected(db, file); err != nil {
+			resp.WriteHeader(http.StatusForbidden)
+			return err
+		}
+		if file, variant, err = b.variantOf(db, file, variant); err != nil {
+			if e, ok := err.(*errs.Error); ok && e.Code() == "args-err" {
+				resp.WriteHeader(http.StatusBadRequest)
+			}
+			return err
+		}
+		if options.Filename == "" {
+			filename = variantFilename(filename, variant.Format)
+		}
+	}
+	deliveryFile, err := b.writeHeader(db, resp, file, options.Disposition, filename)
+	if err != nil {
 		return err
 	}
-	if b.RedirectPathPrefix != "" {
-		resp.Header().Set("X-Accel-Redirect", path.Join(b.RedirectPathPrefix, b.FilePath(file)))
-		return nil
+	delivery := b.delivery()
+	if _, ok := delivery.(DirectSend); !ok && !b.hasLocalFile(file) {
+		delivery = DirectSend{}
 	}
-
-	f, err := os.Open(filepath.Join(b.Dir, b.FilePath(file)))
-	if err != nil {
-		if os.IsNotExist(err) {
-			resp.WriteHeader(http.StatusNotFound)
-			return nil
-		}
+	recorder := &statusRecorder{ResponseWriter: resp}
+	if err := delivery.Deliver(recorder, req, deliveryFile); err != nil {
 		return err
 	}
-	defer f.Close()
+	if recorder.succeeded() {
+		b.recordAccess(file, object)
+	}
+	return nil
+}
 
-	_, err = io.Copy(resp, f)
-	return err
+// hasLocalFile returns if a file is on this machine, or true if there are no other machines to read it from.
+func (b *Bucket) hasLocalFile(hash string) bool {
+	if len(b.otherMachines) == 0 {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(b.Dir, b.FilePath(hash)))
+	return err == nil
 }
 
-func (b *Bucket) writeHeader(db DB, resp http.ResponseWriter, file string) error {
+// writeHeader writes the Content-Type and security headers, and returns the file to deliver.
+func (b *Bucket) writeHeader(
+	db DB, resp http.ResponseWriter, file, disposition, filename string,
+) (*DeliveryFile, error) {
 	row := b.getDB(db).QueryRow(
-		fmt.Sprintf(`SELECT type FROM %s WHERE hash = %s`, b.FilesTable, quote(file)),
+		fmt.Sprintf(`SELECT type, infected FROM %s WHERE hash = %s`, b.FilesTable, quote(file)),
 	)
-	var contentType string
-	if err := row.Scan(&contentType); err != nil && err != sql.ErrNoRows {
-		return err
+	var contentType, infected string
+	if err := row.Scan(&contentType, &infected); err != nil {
+		// responded before reading the file from other machines, so unknown hashes don't cause ssh commands.
+		if err == sql.ErrNoRows {
+			resp.WriteHeader(http.StatusNotFound)
+			return nil, errFileNotFound
+		}
+		return nil, err
+	}
+	if infected != "" {
+		resp.WriteHeader(http.StatusForbidden)
+		return nil, errInfected
 	}
+	deliveryFile := &DeliveryFile{Hash: file, Dir: b.Dir, Path: b.FilePath(file), bucket: b}
 	if contentType != "" {
 		resp.Header().Set("Content-Type", contentType)
-		resp.Header().Set("Expires", "Thu, 31 Dec 2037 23:55:55 GMT")
+		// the content of a hash never changes.
+		deliveryFile.ETag = `"` + file + `"`
+		deliveryFile.CacheControl = b.cacheControl()
 	}
-	return nil
+	b.writeActiveContentHeader(resp, contentType)
+	if b.forceAttachment(contentType) {
+		disposition = DispositionAttachment
+		deliveryFile.ForcedDisposition = true
+	}
+	if disposition != "" || filename != "" {
+		if disposition == "" {
+			disposition = DispositionInline
+		}
+		deliveryFile.Disposition = ContentDisposition(disposition, filename)
+	}
+	return deliveryFile, nil
+}
+
+// cacheControl returns "private" if downloads are authorized by user, so shared caches don't store them.
+func (b *Bucket) cacheControl() string {
+	if b.Authorizer != nil || b.DownloadURLExpiry > 0 {
+		return "private, max-age=31536000, immutable"
+	}
+	return "public, max-age=31536000, immutable"
+}
+
+// etagMatch checks if an ETag matches the If-None-Match header, weak comparison is used.
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
 }
 
 var errInvalidHash = errs.New("args-err", "invalid file hash")
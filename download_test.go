@@ -0,0 +1,38 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+)
+
+func ExampleBucket_ServeDownload() {
+	hashes, err := testBucket.Save(nil, nil, "download", File{IO: strings.NewReader("hello world"), Size: 11})
+	if err != nil {
+		panic(err)
+	}
+	url := testBucket.DownloadURL("download", hashes[0])
+
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("Range", "bytes=6-")
+	resp := httptest.NewRecorder()
+	fmt.Println(testBucket.ServeDownload(nil, req, resp))
+	fmt.Println(resp.Code, resp.Header().Get("Content-Range"), resp.Body.String())
+
+	req = httptest.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", resp.Header().Get("ETag"))
+	resp = httptest.NewRecorder()
+	fmt.Println(testBucket.ServeDownload(nil, req, resp))
+	fmt.Println(resp.Code, resp.Body.Len())
+
+	resp = httptest.NewRecorder()
+	fmt.Println(testBucket.ServeDownload(nil, httptest.NewRequest("HEAD", url, nil), resp))
+	fmt.Println(resp.Code, resp.Header().Get("Content-Length"), resp.Body.Len())
+	// Output:
+	// <nil>
+	// 206 bytes 6-10/11 world
+	// <nil>
+	// 304 0
+	// <nil>
+	// 200 11 0
+}
@@ -4,8 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"image"
 	"io"
-	"net/http"
+	"os"
 	"strings"
 	"time"
 )
@@ -21,58 +22,102 @@ func (b *Bucket) createFilesTable(db DB) error {
 		size           int8        NOT NULL,
 		tranformations jsonb       NOT NULL DEFAULT '{}',
 		created_at     timestamptz NOT NULL
-	)`, b.FilesTable,
+	);
+	ALTER TABLE %s ADD COLUMN IF NOT EXISTS infected text NOT NULL DEFAULT '';
+	ALTER TABLE %s ADD COLUMN IF NOT EXISTS parent text NOT NULL DEFAULT '';
+	ALTER TABLE %s ADD COLUMN IF NOT EXISTS width int4 NOT NULL DEFAULT 0;
+	ALTER TABLE %s ADD COLUMN IF NOT EXISTS height int4 NOT NULL DEFAULT 0;
+	`, b.FilesTable, b.FilesTable, b.FilesTable, b.FilesTable, b.FilesTable,
 	))
 	return err
 }
 
 type fileRecord struct {
-	Hash string
-	Type string
-	Size int64
-	File io.Reader
+	Hash  string
+	Alias string // The hash of the original file if it's processed.
+	Name  string // The original file name, maybe empty.
+	Type  string
+	Size  int64
+	File  io.Reader
+
+	Width, Height int // The dimensions of images, zero for other files.
 }
 
+// createFileRecords checks and processes files, the temporary files of processed files are returned
+// to be closed by the caller after the files are saved.
 func (b *Bucket) createFileRecords(
-	db DB, files []File, fileCheck func(string, int64) error,
-) ([]fileRecord, error) {
-	records := make([]fileRecord, 0, len(files))
+	db DB, files []File, checker FileChecker,
+) (records []fileRecord, temps []*os.File, err error) {
+	records = make([]fileRecord, 0, len(files))
 	for _, file := range files {
-		contentType, err := getContentType(file.IO)
+		contentType, err := SniffContentType(file.IO, file.Size, file.Name, file.Type)
 		if err != nil {
-			return records, err
+			return records, temps, err
+		}
+		if sanitized, err := b.handleActiveContent(checkerLang(checker), contentType, file.IO); err != nil {
+			return records, temps, err
+		} else if sanitized != nil {
+			file.IO, file.Size = sanitized, sanitized.Size()
+		}
+		// files are checked before processed, so oversize files and images are rejected before decoded.
+		if err := check(checker, FileInfo{Name: file.Name, Type: contentType, Size: file.Size, IO: file.IO}); err != nil {
+			return records, temps, err
 		}
-		if fileCheck != nil {
-			if err := fileCheck(contentType, file.Size); err != nil {
-				return records, err
+		var alias string
+		if processed, err := b.process(checkerLang(checker), contentType, file.IO); err != nil {
+			return records, temps, err
+		} else if processed != nil {
+			temps = append(temps, processed)
+			if alias, err = getContentHash(file.IO); err != nil {
+				return records, temps, err
+			}
+			info, err := processed.Stat()
+			if err != nil {
+				return records, temps, err
+			}
+			file.IO, file.Size = processed, info.Size()
+			if contentType, err = SniffContentType(file.IO, file.Size, file.Name, file.Type); err != nil {
+				return records, temps, err
 			}
+			if err := check(checker, FileInfo{
+				Name: file.Name, Type: contentType, Size: file.Size, IO: file.IO,
+			}); err != nil {
+				return records, temps, err
+			}
+		}
+		if err := b.scan(checkerLang(checker), file.IO); err != nil {
+			return records, temps, err
 		}
 		hash, err := getContentHash(file.IO)
 		if err != nil {
-			return records, err
+			return records, temps, err
+		}
+		record := fileRecord{
+			Hash: hash, Alias: alias, Name: file.Name, Type: contentType, Size: file.Size, File: file.IO,
 		}
-		records = append(records, fileRecord{
-			Hash: hash, Type: contentType, Size: file.Size, File: file.IO,
-		})
+		if record.Width, record.Height, err = imageSize(contentType, file.IO); err != nil {
+			return records, temps, err
+		}
+		records = append(records, record)
 	}
 	if err := b.insertFileRecords(db, records); err != nil {
-		return records, err
+		return records, temps, err
 	}
-	return records, nil
+	return records, temps, nil
 }
 
 func (b *Bucket) insertFileRecords(db DB, records []fileRecord) error {
 	var values []string
 	now := fmtTime(time.Now())
 	for _, record := range records {
-		values = append(values, fmt.Sprintf("(%s, %s, %d, %s)",
-			quote(record.Hash), quote(record.Type), record.Size, now,
+		values = append(values, fmt.Sprintf("(%s, %s, %d, %d, %d, %s)",
+			quote(record.Hash), quote(record.Type), record.Size, record.Width, record.Height, now,
 		))
 	}
 
 	rows, err := b.getDB(db).Query(fmt.Sprintf(`
 	INSERT INTO %s
-		(hash, type, size, created_at)
+		(hash, type, size, width, height, created_at)
 	VALUES
 		%s
 	ON CONFLICT (hash) DO NOTHING
@@ -92,20 +137,26 @@ func (b *Bucket) insertFileRecords(db DB, records []fileRecord) error {
 		}
 		inserted = append(inserted, hash)
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	return err
+	return b.insertAliases(db, records)
 }
 
-func getContentType(file io.ReadSeeker) (string, error) {
-	var array [512]byte
-	n, err := file.Read(array[:])
-	if err != nil {
-		return "", err
+// imageSize returns the dimensions of an image, or zeros if it's not an image of a decodable format.
+func imageSize(contentType string, file io.ReadSeeker) (int, int, error) {
+	if !strings.HasPrefix(contentType, "image/") {
+		return 0, 0, nil
 	}
+	config, _, err := image.DecodeConfig(file)
 	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return "", err
+		return 0, 0, err
 	}
-	return http.DetectContentType(array[:n]), nil
+	if err != nil {
+		return 0, 0, nil
+	}
+	return config.Width, config.Height, nil
 }
 
 func getContentHash(file io.ReadSeeker) (string, error) {
@@ -123,6 +174,14 @@ func fmtTime(t time.Time) string {
 	return t.Format("'2006-01-02T15:04:05.999999Z07:00'")
 }
 
+func quoteList(values []string) string {
+	var quoted = make([]string, len(values))
+	for i := range values {
+		quoted[i] = quote(values[i])
+	}
+	return strings.Join(quoted, ", ")
+}
+
 func quote(s string) string {
 	s = strings.Replace(s, "'", "''", -1)
 	s = strings.Replace(s, "\000", "", -1)
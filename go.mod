@@ -8,5 +8,6 @@ require (
 	github.com/lovego/addrs v0.0.1
 	github.com/lovego/errs v0.0.2
 	github.com/lovego/logger v0.0.1
+	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb
 	golang.org/x/text v0.3.6
 )
@@ -0,0 +1,314 @@
+package filestorage
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+/*
+Handler serves the http api of a bucket, or all registered buckets if Bucket is nil.
+The api is routed by the last element of the url path:
+
+	GET|HEAD /download  download a file, or a zip archive of an object's files, see ServeDownload.
+	GET|HEAD /:bucket/:hash download a file by a path-style url, see PathStyleURLs.
+	POST     /upload    upload files, see UploadWithPolicy, or UploadWithToken if the "token" parameter is present.
+	POST     /preupload check if a file can be uploaded instantly, see PreUpload.
+	GET      /stat      get the metadata of a file, or a list of multiple files or all files of an object, see StatMany.
+	                    Files of an object are listed only if Authorizer is present or download urls are signed.
+	POST     /link      link files to an object, see Link.
+	POST     /unlink    unlink files from an object, see Unlink.
+
+Link and unlink are refused with 403 if Bucket.Authorizer is absent, since they can make files be cleaned.
+
+The file and object parameters are "f" and "o", like download urls, and multiple "f" are allowed for link
+and unlink. Results except downloads are responded as JSON like {"code": "ok", "data": ...},
+and errors like {"code": "args-err", "message": "invalid file hash"}, with the status code mapped
+from the error code by StatusOf.
+*/
+type Handler struct {
+	Bucket *Bucket
+	// The policy registered in Bucket.Policies to check uploaded files, except uploads with tokens,
+	// which are checked by the policy of tokens. If it's empty, only images not larger than MaxSize are allowed.
+	Policy string
+	// Max upload size if no policy is specified, defaults to 2MiB.
+	MaxSize int64
+	CORS    *CORS
+	Logger  Logger
+}
+
+// CORS is the cross-origin resource sharing config, it should be made by NewCORS.
+type CORS struct {
+	Origins     []string // Allowed origins, "*" allows all origins if Credentials is false.
+	Credentials bool     // If true, cookies and authorization headers are allowed.
+	MaxAge      time.Duration
+}
+
+var errCORSWildcard = errors.New(`CORS: the "*" origin is not allowed with credentials`)
+
+// NewCORS makes a CORS config, the "*" origin and credentials can't be allowed together,
+// otherwise every site can make requests with the cookies of users.
+func NewCORS(origins []string, credentials bool, maxAge time.Duration) (*CORS, error) {
+	c := &CORS{Origins: origins, Credentials: credentials, MaxAge: maxAge}
+	if credentials && c.allowAll() {
+		return nil, errCORSWildcard
+	}
+	return c, nil
+}
+
+var errNotFound = errs.New("not-found", "not found")
+
+func (h *Handler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if h.CORS != nil && h.CORS.writeHeader(resp, req) {
+		return
+	}
+	w := &responseWriter{ResponseWriter: resp}
+	data, err := h.serve(w, req)
+	if w.wrote {
+		if err != nil && h.Logger != nil {
+			h.Logger.Error(err)
+		}
+		return
+	}
+	if err == nil && w.status != 0 {
+		err = errs.New(codeOf(w.status), http.StatusText(w.status))
+	}
+	if err != nil {
+		status := w.status
+		if status == 0 {
+			status = StatusOf(err)
+		}
+		h.writeError(resp, status, err)
+		return
+	}
+	if data != nil {
+		writeJSON(resp, http.StatusOK, map[string]interface{}{"code": "ok", "data": data})
+	}
+}
+
+func (h *Handler) serve(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	q := DownloadQuery(req)
+	b := h.Bucket
+	if b == nil {
+		name := q.Get("b")
+		if name == "" {
+			name = q.Get("bucket")
+		}
+		var err error
+		if b, err = GetBucket(name); err != nil {
+			return nil, err
+		}
+	}
+	lang := q.Get("lang")
+
+	switch route := path.Base(req.URL.Path); {
+	case (route == "download" || IsHash(route)) && (req.Method == http.MethodGet || req.Method == http.MethodHead):
+		return nil, b.ServeDownload(nil, req, resp)
+	case route == "upload" && req.Method == http.MethodPost:
+		if q.Get("token") != "" {
+			return UploadWithToken(req, lang)
+		}
+		return b.UploadWithPolicy(req, lang, h.Policy, h.MaxSize)
+	case route == "preupload" && req.Method == http.MethodPost:
+		checker, err := b.uploadChecker(h.Policy, lang, h.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		return b.preUpload(req, checker)
+	case route == "stat" && req.Method == http.MethodGet:
+		return h.stat(b, req)
+	case route == "link" && req.Method == http.MethodPost:
+		if err := b.authorizeMutation(req, q.Get("o"), OpUploadLink); err != nil {
+			return nil, err
+		}
+		return q["f"], b.Link(nil, q.Get("o"), q["f"]...)
+	case route == "unlink" && req.Method == http.MethodPost:
+		if err := b.authorizeMutation(req, q.Get("o"), OpUnlink); err != nil {
+			return nil, err
+		}
+		return q["f"], b.Unlink(nil, q.Get("o"), q["f"]...)
+	}
+	return nil, errNotFound
+}
+
+// stat returns the metadata of a file, or the metadata list of multiple files or all files of an object.
+func (h *Handler) stat(b *Bucket, req *http.Request) (interface{}, error) {
+	q := DownloadQuery(req)
+	if err := b.VerifyDownloadURL(req); err != nil {
+		return nil, err
+	}
+	object, files := q.Get("o"), q["f"]
+	if err := b.Authorize(req, object, OpDownload); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if object == "" {
+			return nil, errInvalidHash
+		}
+		// anyone knowing an object shouldn't get its files, unless the request is authorized or signed.
+		if !b.canListObject() {
+			return nil, errForbidden
+		}
+		var err error
+		if files, err = b.FilesOf(nil, object); err != nil {
+			return nil, err
+		}
+	} else if object != "" {
+		for _, file := range files {
+			if err := b.EnsureLinked(nil, object, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(q["f"]) == 1 {
+		return b.Stat(nil, files[0])
+	}
+	stats, err := b.StatMany(nil, files)
+	if stats == nil {
+		stats = []*FileStat{}
+	}
+	return stats, err
+}
+
+func (h *Handler) writeError(resp http.ResponseWriter, status int, err error) {
+	if status == http.StatusInternalServerError && h.Logger != nil {
+		h.Logger.Error(err)
+	}
+	body := map[string]interface{}{"code": "server-err", "message": "Server Error."}
+	if e, ok := err.(*errs.Error); ok {
+		body = map[string]interface{}{"code": e.Code(), "message": e.Message()}
+		if data := e.Data(); data != nil {
+			body["data"] = data
+		}
+	}
+	// remove the headers of the file set before the error.
+	for _, key := range []string{"ETag", "Cache-Control", "Content-Disposition", "Content-Length"} {
+		resp.Header().Del(key)
+	}
+	writeJSON(resp, status, body)
+}
+
+// StatusOf maps the code of an errs.Error to a http status code.
+func StatusOf(err error) int {
+	e, ok := err.(*errs.Error)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	switch e.Code() {
+	case "args-err", "bad-request":
+		return http.StatusBadRequest
+	case "forbidden":
+		return http.StatusForbidden
+	case "not-found":
+		return http.StatusNotFound
+	case "too-many-downloads":
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func codeOf(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "args-err"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not-found"
+	case http.StatusServiceUnavailable:
+		return "too-many-downloads"
+	}
+	return "server-err"
+}
+
+func writeJSON(resp http.ResponseWriter, status int, body interface{}) {
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	resp.WriteHeader(status)
+	_ = json.NewEncoder(resp).Encode(body)
+}
+
+// responseWriter holds back error status written by handlers, so that errors can be responded as JSON.
+// The held status is written if the handler writes a body, like the error pages of http.ServeContent.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wrote {
+		return
+	}
+	if status >= 400 {
+		w.status = status
+		return
+	}
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wrote {
+		w.wrote = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// writeHeader writes the CORS headers, it returns true if the request is a preflight request and it's handled.
+func (c *CORS) writeHeader(resp http.ResponseWriter, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	resp.Header().Add("Vary", "Origin")
+	if origin == "" || !c.allow(origin) {
+		return false
+	}
+	header := resp.Header()
+	if c.Credentials {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Set("Access-Control-Allow-Credentials", "true")
+	} else if c.allowAll() {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+		header.Set("Access-Control-Expose-Headers", "Content-Disposition, Content-Range, ETag")
+		return false
+	}
+	header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST")
+	if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		header.Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	resp.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// allow returns if an origin is allowed, "*" is ignored if Credentials is true.
+func (c *CORS) allow(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" && !c.Credentials || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) allowAll() bool {
+	for _, o := range c.Origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
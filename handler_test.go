@@ -0,0 +1,67 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func ExampleHandler() {
+	hashes, err := testBucket.Save(nil, nil, "", File{IO: strings.NewReader("handler"), Size: 7})
+	if err != nil {
+		panic(err)
+	}
+	bucket := *testBucket
+	bucket.Authorizer = AuthorizeFunc(func(req *http.Request, o LinkObject, op Operation) (bool, error) {
+		return req.Header.Get("User") == "1", nil
+	})
+	cors, err := NewCORS([]string{"*"}, false, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	handler := &Handler{Bucket: &bucket, CORS: cors}
+	for _, url := range []string{
+		"/link?o=handlers|1|files&f=" + hashes[0],
+		"/link?o=handlers|1|files&f=invalid",
+		"/unlink?o=handlers|1|files&f=" + hashes[0],
+	} {
+		resp := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", url, nil)
+		req.Header.Set("User", "1")
+		handler.ServeHTTP(resp, req)
+		fmt.Print(resp.Code, " ", resp.Body.String())
+	}
+
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/download?f="+hashes[0], nil)
+	req.Header.Set("Origin", "http://example.com")
+	handler.ServeHTTP(resp, req)
+	fmt.Println(resp.Code, resp.Header().Get("Access-Control-Allow-Origin"), resp.Body.String())
+	// Output:
+	// 200 {"code":"ok","data":["MSg4m7HCG6_qp7bmJwJlWPKEWjgX-IQ2klse2fhFlz4"]}
+	// 400 {"code":"args-err","message":"invalid file hash"}
+	// 200 {"code":"ok","data":["MSg4m7HCG6_qp7bmJwJlWPKEWjgX-IQ2klse2fhFlz4"]}
+	// 200 * handler
+}
+
+func ExampleHandler_unauthorized() {
+	// without an Authorizer, anonymous requests can't link or unlink files.
+	handler := &Handler{Bucket: testBucket}
+	for _, url := range []string{
+		"/link?o=handlers|1|files&f=MSg4m7HCG6_qp7bmJwJlWPKEWjgX-IQ2klse2fhFlz4",
+		"/unlink?o=handlers|1|files&f=MSg4m7HCG6_qp7bmJwJlWPKEWjgX-IQ2klse2fhFlz4",
+	} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest("POST", url, nil))
+		fmt.Print(resp.Code, " ", resp.Body.String())
+	}
+
+	_, err := NewCORS([]string{"*"}, true, 0)
+	fmt.Println(err)
+	// Output:
+	// 403 {"code":"forbidden","message":"access denied"}
+	// 403 {"code":"forbidden","message":"access denied"}
+	// CORS: the "*" origin is not allowed with credentials
+}
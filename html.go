@@ -27,6 +27,13 @@ func (b *Bucket) ImgSrcToDownloadURL(linkObject interface{}, html string) string
 	})
 }
 
+// ImgSrcToDownloadURLWithOptions is like ImgSrcToDownloadURL, but make download urls with options.
+func (b *Bucket) ImgSrcToDownloadURLWithOptions(linkObject interface{}, html string, options URLOptions) string {
+	return ReplaceImgSrc(html, func(src string) string {
+		return b.DownloadURLWithOptions(linkObject, src, options)
+	})
+}
+
 func ReplaceImgSrc(html string, fn func(src string) string) string {
 	indexes := imgSrcRegexp.FindAllStringSubmatchIndex(html, -1)
 	if len(indexes) == 0 {
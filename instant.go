@@ -0,0 +1,263 @@
+package filestorage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+const (
+	instantProofLength = 64 << 10
+	instantTokenTTL    = 10 * time.Minute
+)
+
+var errInvalidInstantToken = errs.New("forbidden", "invalid instant upload token")
+var errInstantTokenExpired = errs.New("forbidden", "instant upload token expired")
+var errInvalidProof = errs.New("forbidden", "invalid proof of file content")
+
+// PreUploadResult is the result of PreUpload.
+type PreUploadResult struct {
+	// If true, the file is linked to linkObject, and it needn't be uploaded.
+	Linked bool `json:"linked"`
+	// If present, the file exists, and the client should prove it has the file to link it instantly.
+	// If both Linked and Challenge are absent, the client should upload the file.
+	Challenge *InstantChallenge `json:"challenge,omitempty"`
+}
+
+// InstantChallenge asks a client to prove it has the content of a file, by the content hash of a random
+// nonce followed by a byte range chosen by the server, so knowing the hash and size of a file is not
+// enough to link it, even if the byte range is the whole file.
+type InstantChallenge struct {
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Nonce  string `json:"nonce"`
+	// The challenge signed by SignKeys, it should be sent back with the proof.
+	Token string `json:"token"`
+}
+
+type instantToken struct {
+	Bucket  string `json:"b"`
+	Hash    string `json:"h"`
+	Size    int64  `json:"s"`
+	Offset  int64  `json:"o"`
+	Length  int64  `json:"l"`
+	Nonce   string `json:"n"`
+	Expires int64  `json:"e"`
+}
+
+// PreUpload checks if a file can be uploaded instantly, like UploadWithMaxSize, the bucket is
+// specified by the "bucket" query parameter. See Bucket.PreUpload.
+func PreUpload(req *http.Request, lang string, maxSize int64) (*PreUploadResult, error) {
+	bucket, err := GetBucket(req.URL.Query().Get("bucket"))
+	if err != nil {
+		return nil, err
+	}
+	return bucket.PreUpload(req, lang, maxSize)
+}
+
+/*
+PreUpload is called by clients before uploading a file, to skip transferring content that already exists.
+Authorizer is required, and the "linkObject" query parameter is authorized like UploadDefault.
+It takes two requests to link a file instantly:
+ 1. The "hash" and "size" query parameters are the content hash and size of the file computed by the client.
+    If the file exists, a challenge is returned, otherwise the client should upload the file.
+ 2. The "token" query parameter is the token of the challenge, and the "proof" query parameter is the
+    content hash(like file hashes) of the challenge nonce followed by the challenged byte range.
+    If the proof is right, the file is checked like UploadDefault and linked to linkObject.
+*/
+func (b *Bucket) PreUpload(req *http.Request, lang string, maxSize int64) (*PreUploadResult, error) {
+	checker, err := b.uploadChecker("", lang, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return b.preUpload(req, checker)
+}
+
+func (b *Bucket) preUpload(req *http.Request, checker FileChecker) (*PreUploadResult, error) {
+	q := req.URL.Query()
+	if err := b.authorizeMutation(req, q.Get("linkObject"), OpUploadLink); err != nil {
+		return nil, err
+	}
+	if token := q.Get("token"); token != "" {
+		linked, err := b.LinkExisting(nil, checker, q.Get("linkObject"), token, q.Get("proof"))
+		if err != nil {
+			return nil, err
+		}
+		return &PreUploadResult{Linked: linked}, nil
+	}
+	size, err := strconv.ParseInt(q.Get("size"), 10, 64)
+	if err != nil || size <= 0 {
+		return nil, errs.New("args-err", "invalid size")
+	}
+	challenge, err := b.ChallengeExisting(nil, q.Get("hash"), size)
+	if err != nil {
+		return nil, err
+	}
+	return &PreUploadResult{Challenge: challenge}, nil
+}
+
+// ChallengeExisting returns a challenge to prove having the content of an existing file,
+// or nil if the file doesn't exist with the same size, then the file should be uploaded.
+// Files processed by Processor can't be challenged by the hash of the original file,
+// because the original file is not stored. SignKeys is required to sign challenges.
+func (b *Bucket) ChallengeExisting(db DB, hash string, size int64) (*InstantChallenge, error) {
+	if err := CheckHash(hash); err != nil {
+		return nil, err
+	}
+	row := b.getDB(db).QueryRow(fmt.Sprintf(
+		`SELECT infected FROM %s WHERE hash = %s AND size = %d`, b.FilesTable, quote(hash), size,
+	))
+	var infected string
+	if err := row.Scan(&infected); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// an infected file is rejected when it's uploaded and scanned again.
+	if infected != "" {
+		return nil, nil
+	}
+	t := instantToken{
+		Bucket: b.Name, Hash: hash, Size: size, Length: size, Expires: time.Now().Add(instantTokenTTL).Unix(),
+	}
+	if t.Length > instantProofLength {
+		t.Length = instantProofLength
+		offset, err := rand.Int(rand.Reader, big.NewInt(size-t.Length+1))
+		if err != nil {
+			return nil, err
+		}
+		t.Offset = offset.Int64()
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	t.Nonce = base64.RawURLEncoding.EncodeToString(nonce)
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature, err := b.sign([]byte(payload))
+	if err != nil {
+		return nil, err
+	}
+	return &InstantChallenge{
+		Offset: t.Offset, Length: t.Length, Nonce: t.Nonce, Token: payload + "." + signature,
+	}, nil
+}
+
+// LinkExisting links an existing file to object without transferring its content, if proof is the content
+// hash of the nonce followed by the byte range challenged by token, which is returned by ChallengeExisting.
+// The file is checked by checker if checker is not nil.
+// It returns false if the file doesn't exist anymore, then the file should be uploaded.
+// Authorization of the object must be done by the caller.
+func (b *Bucket) LinkExisting(db DB, checker FileChecker, object, token, proof string) (bool, error) {
+	t, err := b.parseInstantToken(token)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := b.verifyProof(t, proof); err != nil {
+		if os.IsNotExist(err) {
+			// upload again to restore the missing file.
+			return false, nil
+		}
+		return false, err
+	} else if !ok {
+		return false, errInvalidProof
+	}
+	row := b.getDB(db).QueryRow(fmt.Sprintf(
+		`SELECT type, infected FROM %s WHERE hash = %s AND size = %d`, b.FilesTable, quote(t.Hash), t.Size,
+	))
+	var contentType, infected string
+	if err := row.Scan(&contentType, &infected); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if infected != "" {
+		return false, nil
+	}
+	if checker != nil {
+		var file io.ReadSeeker
+		if b.localMachine {
+			f, err := os.Open(filepath.Join(b.Dir, b.FilePath(t.Hash)))
+			if err != nil {
+				if os.IsNotExist(err) {
+					return false, nil
+				}
+				return false, err
+			}
+			defer f.Close()
+			file = f
+		}
+		if err := checker.Check(FileInfo{Type: contentType, Size: t.Size, IO: file}); err != nil {
+			return false, err
+		}
+	}
+	if object != "" {
+		if err := b.Link(db, object, t.Hash); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (b *Bucket) parseInstantToken(token string) (instantToken, error) {
+	var t instantToken
+	i := strings.IndexByte(token, '.')
+	if i < 0 || !b.verify([]byte(token[:i]), token[i+1:]) {
+		return t, errInvalidInstantToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return t, errInvalidInstantToken
+	}
+	if err := json.Unmarshal(data, &t); err != nil || t.Bucket != b.Name || !IsHash(t.Hash) || t.Nonce == "" {
+		return instantToken{}, errInvalidInstantToken
+	}
+	if time.Now().Unix() > t.Expires {
+		return instantToken{}, errInstantTokenExpired
+	}
+	return t, nil
+}
+
+// verifyProof checks if proof is the content hash of the nonce followed by the challenged byte range
+// of the stored file.
+func (b *Bucket) verifyProof(t instantToken, proof string) (bool, error) {
+	f, err := b.openFile(t.Hash)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if file, ok := f.(*os.File); ok {
+		_, err = file.Seek(t.Offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(ioutil.Discard, f, t.Offset)
+	}
+	if err != nil {
+		return false, err
+	}
+	h := sha256.New()
+	h.Write([]byte(t.Nonce))
+	if _, err := io.CopyN(h, f, t.Length); err != nil {
+		return false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)) == proof, nil
+}
@@ -0,0 +1,72 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+func ExampleBucket_LinkExisting() {
+	bucket := *testBucket
+	bucket.SignKeys = []string{"instant"}
+	content := []byte("GIF89a instant upload")
+	hashes, err := bucket.Save(nil, nil, "", File{IO: bytes.NewReader(content), Size: int64(len(content))})
+	if err != nil {
+		panic(err)
+	}
+	size := int64(len(content))
+
+	fmt.Println(bucket.ChallengeExisting(nil, hashes[0], size+1))
+	challenge, err := bucket.ChallengeExisting(nil, hashes[0], size)
+	fmt.Println(challenge.Offset, challenge.Length, err)
+
+	proof, err := getContentHash(bytes.NewReader(
+		append([]byte(challenge.Nonce), content[challenge.Offset:challenge.Offset+challenge.Length]...),
+	))
+	if err != nil {
+		panic(err)
+	}
+	// the content hash of the file is not a proof, even if the whole file is challenged.
+	fmt.Println(bucket.LinkExisting(nil, nil, "instant", challenge.Token, hashes[0]))
+	fmt.Println(bucket.LinkExisting(nil, nil, "instant", challenge.Token, proof))
+	fmt.Println(bucket.Linked(nil, "instant", hashes[0]))
+	// Output:
+	// <nil> <nil>
+	// 0 21 <nil>
+	// false forbidden: invalid proof of file content
+	// true <nil>
+	// true <nil>
+}
+
+func ExampleBucket_PreUpload() {
+	bucket := *testBucket
+	bucket.SignKeys = []string{"instant"}
+	content := []byte("GIF89a pre upload")
+	hashes, err := bucket.Save(nil, nil, "", File{IO: bytes.NewReader(content), Size: int64(len(content))})
+	if err != nil {
+		panic(err)
+	}
+	q := url.Values{"hash": {hashes[0]}, "size": {fmt.Sprint(len(content))}, "linkObject": {"users|2|avatar"}}
+	_, err = bucket.PreUpload(httptest.NewRequest("POST", "/?"+q.Encode(), nil), "en", 0)
+	fmt.Println(err)
+
+	bucket.Authorizer = AuthorizeFunc(func(*http.Request, LinkObject, Operation) (bool, error) {
+		return true, nil
+	})
+	result, err := bucket.PreUpload(httptest.NewRequest("POST", "/?"+q.Encode(), nil), "en", 0)
+	fmt.Println(result.Linked, result.Challenge != nil, err)
+
+	proof, err := getContentHash(bytes.NewReader(append([]byte(result.Challenge.Nonce), content...)))
+	if err != nil {
+		panic(err)
+	}
+	q = url.Values{"token": {result.Challenge.Token}, "proof": {proof}, "linkObject": {"users|2|avatar"}}
+	result, err = bucket.PreUpload(httptest.NewRequest("POST", "/?"+q.Encode(), nil), "en", 0)
+	fmt.Println(result.Linked, err)
+	// Output:
+	// forbidden: access denied
+	// false true <nil>
+	// true <nil>
+}
@@ -3,8 +3,10 @@ package filestorage
 import (
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/lovego/errs"
 )
@@ -35,13 +37,20 @@ func (b *Bucket) createLinksTable(db DB) error {
 		unique(file, object)
 	);
 	CREATE INDEX IF NOT EXISTS %s_object_index ON %s(object);
-	`, b.LinksTable, b.LinksTable, b.LinksTable,
+	ALTER TABLE %s ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+	`, b.LinksTable, b.LinksTable, b.LinksTable, b.LinksTable,
 	))
 	return err
 }
 
 // Link files to object.
 func (b *Bucket) Link(db DB, object string, files ...string) error {
+	return b.linkNamed(db, object, files, nil)
+}
+
+// linkNamed link files to object with display names, names[i] is the name of files[i].
+// The name of an existing link is updated if the new name is not empty.
+func (b *Bucket) linkNamed(db DB, object string, files, names []string) error {
 	if object == "" {
 		return errEmptyObject
 	}
@@ -51,40 +60,302 @@ func (b *Bucket) Link(db DB, object string, files ...string) error {
 	if err := CheckHash(files...); err != nil {
 		return err
 	}
+	files, err := b.resolveAliases(db, files)
+	if err != nil {
+		return err
+	}
 	if err := b.CheckFile(db, files...); err != nil {
 		return err
 	}
 
 	var values []string
+	var linked = make(map[string]bool)
 	now := fmtTime(time.Now())
-	for _, file := range files {
-		values = append(values, fmt.Sprintf("(%s, %s, %s)", quote(file), quote(object), now))
+	for i, file := range files {
+		var name string
+		if i < len(names) {
+			name = cleanFilename(names[i])
+		}
+		// a row can't be updated twice by one statement, and the first name wins.
+		if linked[file] {
+			continue
+		}
+		linked[file] = true
+		values = append(values, fmt.Sprintf("(%s, %s, %s, %s)", quote(file), quote(object), quote(name), now))
 	}
-	_, err := b.getDB(db).Exec(fmt.Sprintf(`
-	INSERT INTO %s (file, object, created_at)
+	_, err = b.getDB(db).Exec(fmt.Sprintf(`
+	INSERT INTO %s (file, object, name, created_at)
 	VALUES %s
-	ON CONFLICT (file, object) DO NOTHING
+	ON CONFLICT (file, object) DO UPDATE SET name = EXCLUDED.name WHERE EXCLUDED.name != ''
 	`, b.LinksTable, strings.Join(values, ", "),
 	))
 	return err
 }
 
+// LinkName returns the display name of a file linked to object, it's the original file name by default.
+func (b *Bucket) LinkName(db DB, object, file string) (string, error) {
+	if err := CheckHash(file); err != nil {
+		return "", err
+	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return "", err
+	}
+	return b.linkName(db, object, file)
+}
+
+func (b *Bucket) linkName(db DB, object, file string) (string, error) {
+	row := b.getDB(db).QueryRow(fmt.Sprintf(`
+	SELECT name FROM %s WHERE object = %s AND file = %s
+	`, b.LinksTable, quote(object), quote(file),
+	))
+	var name string
+	if err := row.Scan(&name); err != nil {
+		if err == sql.ErrNoRows {
+			return "", errNotLinked
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+// SetLinkName sets the display name of a file linked to object.
+func (b *Bucket) SetLinkName(db DB, object, file, name string) error {
+	if err := CheckHash(file); err != nil {
+		return err
+	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return err
+	}
+	result, err := b.getDB(db).Exec(fmt.Sprintf(`
+	UPDATE %s SET name = %s WHERE object = %s AND file = %s
+	`, b.LinksTable, quote(cleanFilename(name)), quote(object), quote(file),
+	))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotLinked
+	}
+	return nil
+}
+
 // LinkOnly make sure these files and only these files are linked to object.
 func (b *Bucket) LinkOnly(db DB, object string, files ...string) error {
+	_, _, err := b.LinkOnlyDiff(db, object, files...)
+	return err
+}
+
+// LinkOnlyDiff is like LinkOnly, but it returns the files newly linked and the files unlinked.
+func (b *Bucket) LinkOnlyDiff(db DB, object string, files ...string) (added, removed []string, err error) {
 	if object == "" {
+		return nil, nil, errEmptyObject
+	}
+	diffs, err := b.LinkBatch(db, map[string][]string{object: files})
+	if err != nil {
+		return nil, nil, err
+	}
+	return diffs[object].Added, diffs[object].Removed, nil
+}
+
+// LinkDiff is the change of files linked to an object.
+type LinkDiff struct {
+	Added   []string
+	Removed []string
+}
+
+/*
+LinkBatch make sure the files and only the files of each object are linked to the object, like LinkOnly,
+but all objects are synced atomically in one statement, aliases resolved and files checked included.
+It returns the changes of objects, with hashes sorted, and objects not changed are absent.
+An object with no files is unlinked from all files. New links have an empty name like Link,
+and names of links kept are not changed.
+*/
+func (b *Bucket) LinkBatch(db DB, links map[string][]string) (map[string]LinkDiff, error) {
+	var objects, allFiles []string
+	for object := range links {
+		if object == "" {
+			return nil, errEmptyObject
+		}
+		objects = append(objects, object)
+	}
+	if len(objects) == 0 {
+		return map[string]LinkDiff{}, nil
+	}
+	sort.Strings(objects)
+	var values []string
+	for _, object := range objects {
+		files := links[object]
+		if emptyFiles(files) {
+			continue
+		}
+		allFiles = append(allFiles, files...)
+		var linked = make(map[string]bool)
+		for _, file := range files {
+			if !linked[file] {
+				linked[file] = true
+				values = append(values, fmt.Sprintf("(%s, %s)", quote(file), quote(object)))
+			}
+		}
+	}
+	if err := CheckHash(allFiles...); err != nil {
+		return nil, err
+	}
+	input := "SELECT NULL::text AS file, NULL::text AS object WHERE false"
+	if len(values) > 0 {
+		input = "VALUES " + strings.Join(values, ", ")
+	}
+	// nothing is changed if any file doesn't exist, the missing files are returned instead.
+	rows, err := b.getDB(db).Query(fmt.Sprintf(`
+	WITH input(file, object) AS (
+	  %s
+	), wanted AS (
+	  SELECT DISTINCT COALESCE(a.hash, i.file) AS file, i.object
+	  FROM input i LEFT JOIN %s a ON a.alias = i.file
+	), missing AS (
+	  SELECT DISTINCT file FROM wanted w WHERE NOT EXISTS (SELECT 1 FROM %s WHERE hash = w.file)
+	), removed AS (
+	  DELETE FROM %s l
+	  WHERE object IN (%s) AND NOT EXISTS (SELECT 1 FROM missing) AND NOT EXISTS (
+	    SELECT 1 FROM wanted WHERE file = l.file AND object = l.object
+	  )
+	  RETURNING file, object
+	), added AS (
+	  INSERT INTO %s (file, object, name, created_at)
+	  SELECT file, object, '', %s::timestamptz FROM wanted
+	  WHERE NOT EXISTS (SELECT 1 FROM missing)
+	  ON CONFLICT (file, object) DO NOTHING
+	  RETURNING file, object
+	)
+	SELECT 'missing', file, '' FROM missing
+	UNION ALL
+	SELECT 'added', file, object FROM added
+	UNION ALL
+	SELECT 'removed', file, object FROM removed
+	`, input, b.AliasesTable, b.FilesTable, b.LinksTable, quoteList(objects), b.LinksTable, fmtTime(time.Now()),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var diffs = make(map[string]LinkDiff)
+	var missing bool
+	for rows.Next() {
+		var change, file, object string
+		if err := rows.Scan(&change, &file, &object); err != nil {
+			return nil, err
+		}
+		if change == "missing" {
+			missing = true
+			continue
+		}
+		diff := diffs[object]
+		if change == "added" {
+			diff.Added = append(diff.Added, file)
+		} else {
+			diff.Removed = append(diff.Removed, file)
+		}
+		diffs[object] = diff
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if missing {
+		return nil, errFileNotExists
+	}
+	for _, diff := range diffs {
+		sort.Strings(diff.Added)
+		sort.Strings(diff.Removed)
+	}
+	return diffs, nil
+}
+
+// CopyLinks link all files of object from to object to, with the names and creation time of links kept.
+// Files already linked to object to are not changed.
+func (b *Bucket) CopyLinks(db DB, from, to string) error {
+	if from == "" || to == "" {
 		return errEmptyObject
 	}
-	if emptyFiles(files) {
-		return b.unlink(db, object, "")
+	if from == to {
+		return nil
+	}
+	return b.copyLinks(db, fmt.Sprintf("object = %s", quote(from)), quote(to))
+}
+
+// MoveLinks move all links of object from to object to, like CopyLinks and then UnlinkAllOf from atomically.
+func (b *Bucket) MoveLinks(db DB, from, to string) error {
+	if from == "" || to == "" {
+		return errEmptyObject
+	}
+	if from == to {
+		return nil
+	}
+	return runInTx(b.getDB(db), func(tx DB) error {
+		if err := b.copyLinks(tx, fmt.Sprintf("object = %s", quote(from)), quote(to)); err != nil {
+			return err
+		}
+		return b.unlink(tx, from, "")
+	})
+}
+
+// CopyObjectLinks is like CopyLinks, but copies links of every Field of LinkObject{Table, ID},
+// links of "a|1" and "a|1|f" are copied to "b|2" and "b|2|f" respectively. Field of from and to are ignored.
+func (b *Bucket) CopyObjectLinks(db DB, from, to LinkObject) error {
+	cond, target, err := objectPrefix(from, to)
+	if err != nil || cond == "" {
+		return err
 	}
-	return runInTx(db, func(tx DB) error {
-		if err := b.Link(db, object, files...); err != nil {
+	return b.copyLinks(db, cond, target)
+}
+
+// MoveObjectLinks is like MoveLinks, but moves links of every Field of LinkObject{Table, ID},
+// see CopyObjectLinks.
+func (b *Bucket) MoveObjectLinks(db DB, from, to LinkObject) error {
+	cond, target, err := objectPrefix(from, to)
+	if err != nil || cond == "" {
+		return err
+	}
+	return runInTx(b.getDB(db), func(tx DB) error {
+		if err := b.copyLinks(tx, cond, target); err != nil {
 			return err
 		}
-		return b.unlink(db, object, filesCond(files, "NOT"))
+		_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s`, b.LinksTable, cond))
+		return err
 	})
 }
 
+// copyLinks copies links matched by cond to the object of target expression.
+func (b *Bucket) copyLinks(db DB, cond, target string) error {
+	_, err := b.getDB(db).Exec(fmt.Sprintf(`
+	INSERT INTO %s (file, object, name, created_at)
+	SELECT file, %s, name, created_at FROM %s WHERE %s
+	ON CONFLICT (file, object) DO NOTHING
+	`, b.LinksTable, target, b.LinksTable, cond,
+	))
+	return err
+}
+
+// objectPrefix returns the condition to match objects of every Field of from, and the expression to
+// make the target objects. An empty condition is returned if from and to are the same.
+func objectPrefix(from, to LinkObject) (cond, target string, err error) {
+	from.Field, to.Field = "", ""
+	if from.Table == "" || to.Table == "" {
+		return "", "", errInvalidObject
+	}
+	prefix := from.String()
+	if prefix == to.String() {
+		return "", "", nil
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+	cond = fmt.Sprintf(`(object = %s OR object LIKE %s ESCAPE '\')`, quote(prefix), quote(escaped+"|%"))
+	target = fmt.Sprintf("%s || substr(object, %d)", quote(to.String()), utf8.RuneCountInString(prefix)+1)
+	return cond, target, nil
+}
+
 // UnlinkAllOf unlink all linked files from an object.
 func (b *Bucket) UnlinkAllOf(db DB, object string) error {
 	if object == "" {
@@ -104,6 +375,10 @@ func (b *Bucket) Unlink(db DB, object string, files ...string) error {
 	if err := CheckHash(files...); err != nil {
 		return err
 	}
+	files, err := b.resolveAliases(db, files)
+	if err != nil {
+		return err
+	}
 	return b.unlink(db, object, filesCond(files, ""))
 }
 
@@ -129,6 +404,10 @@ func (b *Bucket) Linked(db DB, object, file string) (bool, error) {
 	if err := CheckHash(file); err != nil {
 		return false, err
 	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return false, err
+	}
 	row := b.getDB(db).QueryRow(fmt.Sprintf(`
 	SELECT true FROM %s WHERE object = %s AND file = %s
 	`, b.LinksTable, quote(object), quote(file),
@@ -143,12 +422,69 @@ func (b *Bucket) Linked(db DB, object, file string) (bool, error) {
 // FilesOf get all files linked to an object.
 func (b *Bucket) FilesOf(db DB, object string) ([]string, error) {
 	sql := fmt.Sprintf(`
-	SELECT file FROM %s WHERE object = %s ORDER BY created_at
+	SELECT file FROM %s WHERE object = %s ORDER BY created_at, file
 	`, b.LinksTable, quote(object),
 	)
 	return b.queryFiles(db, sql)
 }
 
+// FilesOfObjects get all files linked to each of objects in one query, objects without files are absent.
+func (b *Bucket) FilesOfObjects(db DB, objects []string) (map[string][]string, error) {
+	if len(objects) == 0 {
+		return map[string][]string{}, nil
+	}
+	rows, err := b.getDB(db).Query(fmt.Sprintf(`
+	SELECT object, file FROM %s WHERE object IN (%s) ORDER BY created_at, object, file
+	`, b.LinksTable, quoteList(objects),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result = make(map[string][]string)
+	for rows.Next() {
+		var object, file string
+		if err := rows.Scan(&object, &file); err != nil {
+			return nil, err
+		}
+		result[object] = append(result[object], file)
+	}
+	return result, rows.Err()
+}
+
+// LinkedObjects get all objects a file is linked to.
+func (b *Bucket) LinkedObjects(db DB, file string) ([]string, error) {
+	if err := CheckHash(file); err != nil {
+		return nil, err
+	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return nil, err
+	}
+	return b.queryFiles(db, fmt.Sprintf(`
+	SELECT object FROM %s WHERE file = %s ORDER BY created_at, object
+	`, b.LinksTable, quote(file),
+	))
+}
+
+// ObjectsOf get all objects a file is linked to, objects not in the LinkObject format are skipped,
+// use LinkedObjects to get them.
+func (b *Bucket) ObjectsOf(db DB, file string) ([]LinkObject, error) {
+	objects, err := b.LinkedObjects(db, file)
+	if err != nil {
+		return nil, err
+	}
+	var result []LinkObject
+	for _, object := range objects {
+		var o LinkObject
+		if err := o.UnmarshalJSON([]byte(object)); err == nil && o.Table != "" {
+			result = append(result, o)
+		}
+	}
+	return result, nil
+}
+
 // CheckFile ensure all files exists.
 func (b *Bucket) CheckFile(db DB, files ...string) error {
 	var values []string
@@ -190,11 +526,7 @@ func (b *Bucket) queryFiles(db DB, sql string) ([]string, error) {
 }
 
 func filesCond(files []string, not string) string {
-	var quoted = make([]string, len(files))
-	for i := range files {
-		quoted[i] = quote(files[i])
-	}
-	return fmt.Sprintf(" AND file %s IN (%s)", not, strings.Join(quoted, ", "))
+	return fmt.Sprintf(" AND file %s IN (%s)", not, quoteList(files))
 }
 
 func emptyFiles(files []string) bool {
@@ -0,0 +1,595 @@
+package filestorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Processor transforms uploaded files after they are checked, and before they are hashed and stored,
+// processed files are checked again.
+type Processor interface {
+	// Process writes the processed file to w and returns true, or returns false without writing to w if
+	// the file is not changed. The file can be seeked to read it more than once without holding it in memory.
+	Process(contentType string, file io.ReadSeeker, w io.Writer) (bool, error)
+}
+
+/*
+ImageNormalizer is a Processor that normalizes JPEG, PNG and WebP images:
+ 1. The EXIF orientation is applied to pixels, so images display upright even in browsers that ignore it.
+ 2. Privacy-sensitive metadata like EXIF(with GPS location), XMP, IPTC and comments are stripped.
+
+To avoid generation loss, images are re-encoded only if they need to be rotated or flipped,
+otherwise metadata is stripped losslessly by copying the other parts of the file, without holding
+the image in memory. Re-encoded JPEG images keep their ICC color profiles. Since there is no WebP
+encoder in the standard library, rotated WebP images are re-encoded as PNG, and the color profiles
+of rotated PNG and WebP images are dropped.
+*/
+type ImageNormalizer struct {
+	Quality int // JPEG quality for re-encoding, defaults to 90.
+	// Images with more pixels than MaxPixels are rejected instead of decoded, defaults to 50 million.
+	MaxPixels int
+}
+
+func (n ImageNormalizer) Process(contentType string, file io.ReadSeeker, w io.Writer) (bool, error) {
+	var layout *imageLayout
+	var err error
+	switch baseMimeType(contentType) {
+	case "image/jpeg":
+		layout, err = scanJPEG(file)
+	case "image/png":
+		layout, err = scanPNG(file)
+	case "image/webp":
+		layout, err = scanWebP(file)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if layout.orientation > 1 && layout.orientation <= 8 {
+		return true, n.reorient(file, contentType, layout, w)
+	}
+	if !layout.stripped {
+		return false, nil
+	}
+	return true, writeParts(file, layout.parts, w)
+}
+
+func (n ImageNormalizer) reorient(file io.ReadSeeker, contentType string, layout *imageLayout, w io.Writer) error {
+	// the stripped image is decoded, so broken metadata doesn't fail decoding.
+	config, _, err := image.DecodeConfig(&partsReader{file: file, parts: layout.parts})
+	if err != nil {
+		return err
+	}
+	maxPixels := n.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = 50 * 1000 * 1000
+	}
+	// checked before decoding to defend against decompression bombs.
+	if int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return fmt.Errorf("image of %dx%d exceeds %d pixels", config.Width, config.Height, maxPixels)
+	}
+	img, _, err := image.Decode(&partsReader{file: file, parts: layout.parts})
+	if err != nil {
+		return err
+	}
+	img = applyOrientation(img, layout.orientation)
+	if baseMimeType(contentType) != "image/jpeg" {
+		return png.Encode(w, img)
+	}
+	quality := n.Quality
+	if quality <= 0 {
+		quality = 90
+	}
+	if len(layout.iccProfile) == 0 {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	// the ICC profile segments are inserted after the SOI marker.
+	encoded := buf.Bytes()
+	if _, err := w.Write(encoded[:2]); err != nil {
+		return err
+	}
+	if err := writeParts(file, layout.iccProfile, w); err != nil {
+		return err
+	}
+	_, err = w.Write(encoded[2:])
+	return err
+}
+
+// applyOrientation rotates or flips an image according to the EXIF orientation(2 to 8).
+func applyOrientation(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontal
+				sx, sy = w-1-x, y
+			case 3: // rotate 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertical
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90 clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 270 clockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+	return dst
+}
+
+// maxExifSize is the max size of EXIF data read to find the orientation, which is always near the start.
+const maxExifSize = 64 << 10
+
+// imageLayout is the parts of an image file to keep when its metadata is stripped.
+type imageLayout struct {
+	parts       []imagePart
+	stripped    bool        // If true, there is metadata to strip.
+	orientation int         // The EXIF orientation.
+	iccProfile  []imagePart // The ICC profile segments of JPEG images, kept when re-encoded.
+}
+
+// imagePart is a byte range of the file, or the data to write instead if data is not nil.
+type imagePart struct {
+	offset, length int64
+	data           []byte
+}
+
+func (p imagePart) size() int64 {
+	if p.data != nil {
+		return int64(len(p.data))
+	}
+	return p.length
+}
+
+// keep keeps a byte range of the file, adjacent ranges are merged to copy them at once.
+func (l *imageLayout) keep(offset, length int64) {
+	if n := len(l.parts); n > 0 && l.parts[n-1].data == nil && l.parts[n-1].offset+l.parts[n-1].length == offset {
+		l.parts[n-1].length += length
+		return
+	}
+	l.parts = append(l.parts, imagePart{offset: offset, length: length})
+}
+
+// writeParts writes the parts of file to w.
+func writeParts(file io.ReadSeeker, parts []imagePart, w io.Writer) error {
+	for _, part := range parts {
+		if part.data != nil {
+			if _, err := w.Write(part.data); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := file.Seek(part.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, file, part.length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partsReader reads the parts of file in order.
+type partsReader struct {
+	file    io.ReadSeeker
+	parts   []imagePart
+	current io.Reader
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current != nil {
+			n, err := r.current.Read(p)
+			if err == io.EOF {
+				r.current = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		if len(r.parts) == 0 {
+			return 0, io.EOF
+		}
+		part := r.parts[0]
+		r.parts = r.parts[1:]
+		if part.data != nil {
+			r.current = bytes.NewReader(part.data)
+			continue
+		}
+		if _, err := r.file.Seek(part.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.current = io.LimitReader(r.file, part.length)
+	}
+}
+
+// readAt reads len(p) bytes at offset of file.
+func readAt(file io.ReadSeeker, offset int64, p []byte) error {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(file, p)
+	return err
+}
+
+// readExif reads the EXIF data at offset of file, at most maxExifSize bytes are read.
+func readExif(file io.ReadSeeker, offset, length int64) ([]byte, error) {
+	if length > maxExifSize {
+		length = maxExifSize
+	}
+	data := make([]byte, length)
+	return data, readAt(file, offset, data)
+}
+
+// scanJPEG finds APP1(EXIF, XMP), APP13(IPTC) and COM segments to strip, and the EXIF orientation.
+func scanJPEG(file io.ReadSeeker) (*imageLayout, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var header [4]byte
+	if size < 4 {
+		return nil, fmt.Errorf("invalid jpeg")
+	}
+	if err := readAt(file, 0, header[:2]); err != nil {
+		return nil, err
+	}
+	if header[0] != 0xFF || header[1] != 0xD8 {
+		return nil, fmt.Errorf("invalid jpeg")
+	}
+	layout := &imageLayout{}
+	layout.keep(0, 2)
+	for i := int64(2); ; {
+		if i+4 > size {
+			return nil, fmt.Errorf("invalid jpeg segment")
+		}
+		if err := readAt(file, i, header[:]); err != nil {
+			return nil, err
+		}
+		if header[0] != 0xFF {
+			return nil, fmt.Errorf("invalid jpeg segment")
+		}
+		marker := header[1]
+		// start of scan: the compressed data follows, no more metadata.
+		if marker == 0xDA {
+			layout.keep(i, size-i)
+			return layout, nil
+		}
+		end := i + 2 + int64(binary.BigEndian.Uint16(header[2:]))
+		if end > size || end < i+4 {
+			return nil, fmt.Errorf("invalid jpeg segment")
+		}
+		switch marker {
+		case 0xE1:
+			layout.stripped = true
+			segment, err := readExif(file, i+4, end-i-4)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				layout.orientation = exifOrientation(segment[6:])
+			}
+		case 0xED, 0xFE:
+			layout.stripped = true
+		default:
+			if marker == 0xE2 && end-i-4 >= 12 {
+				var name [12]byte
+				if err := readAt(file, i+4, name[:]); err != nil {
+					return nil, err
+				}
+				if string(name[:]) == "ICC_PROFILE\x00" {
+					layout.iccProfile = append(layout.iccProfile, imagePart{offset: i, length: end - i})
+				}
+			}
+			layout.keep(i, end-i)
+		}
+		i = end
+	}
+}
+
+var pngMetadataChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// scanPNG finds text, time and EXIF chunks to strip, and the EXIF orientation.
+func scanPNG(file io.ReadSeeker) (*imageLayout, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var header [8]byte
+	if size < 8 {
+		return nil, fmt.Errorf("invalid png")
+	}
+	if err := readAt(file, 0, header[:]); err != nil {
+		return nil, err
+	}
+	if string(header[:]) != signature {
+		return nil, fmt.Errorf("invalid png")
+	}
+	layout := &imageLayout{}
+	layout.keep(0, 8)
+	for i := int64(8); i < size; {
+		if i+12 > size {
+			return nil, fmt.Errorf("invalid png chunk")
+		}
+		if err := readAt(file, i, header[:]); err != nil {
+			return nil, err
+		}
+		// length, type, data and crc.
+		end := i + 12 + int64(binary.BigEndian.Uint32(header[:4]))
+		if end > size {
+			return nil, fmt.Errorf("invalid png chunk")
+		}
+		typ := string(header[4:])
+		if typ == "eXIf" {
+			exif, err := readExif(file, i+8, end-i-12)
+			if err != nil {
+				return nil, err
+			}
+			layout.orientation = exifOrientation(exif)
+		}
+		if pngMetadataChunks[typ] {
+			layout.stripped = true
+		} else {
+			layout.keep(i, end-i)
+		}
+		i = end
+	}
+	return layout, nil
+}
+
+// scanWebP finds EXIF and XMP chunks to strip, and the EXIF orientation.
+func scanWebP(file io.ReadSeeker) (*imageLayout, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < 12 {
+		return nil, fmt.Errorf("invalid webp")
+	}
+	riff := make([]byte, 12)
+	if err := readAt(file, 0, riff); err != nil {
+		return nil, err
+	}
+	if string(riff[:4]) != "RIFF" || string(riff[8:]) != "WEBP" {
+		return nil, fmt.Errorf("invalid webp")
+	}
+	// the RIFF header is rewritten with the size of the stripped file.
+	layout := &imageLayout{parts: []imagePart{{data: riff}}}
+	var vp8x = -1
+	var header [8]byte
+	for i := int64(12); i < size; {
+		if i+8 > size {
+			return nil, fmt.Errorf("invalid webp chunk")
+		}
+		if err := readAt(file, i, header[:]); err != nil {
+			return nil, err
+		}
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:]))
+		end := i + 8 + chunkSize + chunkSize%2 // chunks are padded to even size.
+		if end > size {
+			if end-chunkSize%2 != size {
+				return nil, fmt.Errorf("invalid webp chunk")
+			}
+			end = size
+		}
+		switch typ := string(header[:4]); typ {
+		case "EXIF":
+			layout.stripped = true
+			exif, err := readExif(file, i+8, chunkSize)
+			if err != nil {
+				return nil, err
+			}
+			layout.orientation = exifOrientation(bytes.TrimPrefix(exif, []byte("Exif\x00\x00")))
+		case "XMP ":
+			layout.stripped = true
+		case "VP8X":
+			if chunkSize < 1 || chunkSize > 1<<10 {
+				return nil, fmt.Errorf("invalid webp chunk")
+			}
+			chunk := make([]byte, end-i)
+			if err := readAt(file, i, chunk); err != nil {
+				return nil, err
+			}
+			vp8x = len(layout.parts)
+			layout.parts = append(layout.parts, imagePart{data: chunk})
+		default:
+			layout.keep(i, end-i)
+		}
+		i = end
+	}
+	if vp8x >= 0 {
+		layout.parts[vp8x].data[8] &^= 0x08 | 0x04 // clear the EXIF and XMP flags.
+	}
+	var total int64
+	for _, part := range layout.parts {
+		total += part.size()
+	}
+	binary.LittleEndian.PutUint32(riff[4:], uint32(total-8))
+	return layout, nil
+}
+
+// exifOrientation returns the orientation tag in IFD0 of TIFF formatted EXIF data, or 0 if absent.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) || ifd < 0 {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+func (b *Bucket) createAliasesTable(db DB) error {
+	if b.AliasesTable == "" {
+		b.AliasesTable = "file_aliases"
+	}
+	_, err := b.getDB(db).Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		alias      text        NOT NULL UNIQUE,
+		hash       text        NOT NULL,
+		created_at timestamptz NOT NULL
+	)`, b.AliasesTable,
+	))
+	return err
+}
+
+// process processes a file by Processor into a temporary file, if it's processed, the temporary file is
+// returned, which should be closed by the caller, otherwise nil is returned.
+func (b *Bucket) process(lang, contentType string, file io.ReadSeeker) (*os.File, error) {
+	if b.Processor == nil {
+		return nil, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	temp, err := ioutil.TempFile("", "fs_")
+	if err != nil {
+		return nil, err
+	}
+	// the file is still readable after removed until it's closed.
+	os.Remove(temp.Name())
+	processed, err := b.Processor.Process(contentType, file, temp)
+	if err != nil {
+		temp.Close()
+		return nil, localize(lang, "无法识别的文件内容.", "unrecognized file content.").SetData(err.Error())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	if !processed {
+		temp.Close()
+		return nil, nil
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	return temp, nil
+}
+
+// insertAliases records the hashes of original files as aliases of processed files. If an original file
+// is stored itself, like uploaded before Processor is enabled, it's not an alias, so links to it still work.
+func (b *Bucket) insertAliases(db DB, records []fileRecord) error {
+	var values []string
+	now := fmtTime(time.Now())
+	for _, record := range records {
+		if record.Alias != "" && record.Alias != record.Hash {
+			values = append(values, fmt.Sprintf(
+				"(%s, %s, %s::timestamptz)", quote(record.Alias), quote(record.Hash), now,
+			))
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	_, err := b.getDB(db).Exec(fmt.Sprintf(`
+	INSERT INTO %s (alias, hash, created_at)
+	SELECT * FROM (VALUES %s) AS v(alias, hash, created_at)
+	WHERE NOT EXISTS (SELECT 1 FROM %s WHERE hash = v.alias)
+	ON CONFLICT (alias) DO NOTHING
+	`, b.AliasesTable, strings.Join(values, ", "), b.FilesTable,
+	))
+	return err
+}
+
+// resolveAliases replaces hashes of original files with the hashes of their processed files.
+func (b *Bucket) resolveAliases(db DB, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return hashes, nil
+	}
+	rows, err := b.getDB(db).Query(fmt.Sprintf(
+		`SELECT alias, hash FROM %s WHERE alias IN (%s)`, b.AliasesTable, quoteList(hashes),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var m = make(map[string]string)
+	for rows.Next() {
+		var alias, hash string
+		if err := rows.Scan(&alias, &hash); err != nil {
+			return nil, err
+		}
+		m[alias] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return hashes, nil
+	}
+	resolved := make([]string, len(hashes))
+	for i, hash := range hashes {
+		if h := m[hash]; h != "" {
+			resolved[i] = h
+		} else {
+			resolved[i] = hash
+		}
+	}
+	return resolved, nil
+}
+
+// resolveAlias returns the hash of the processed file if file is an alias, otherwise file itself.
+func (b *Bucket) resolveAlias(db DB, file string) (string, error) {
+	hashes, err := b.resolveAliases(db, []string{file})
+	if err != nil {
+		return "", err
+	}
+	return hashes[0], nil
+}
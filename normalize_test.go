@@ -0,0 +1,74 @@
+package filestorage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+)
+
+// testPNGWithOrientation returns a 4x2 png image with an eXIf chunk of the orientation.
+func testPNGWithOrientation(orientation uint16) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 2))); err != nil {
+		panic(err)
+	}
+	var exif bytes.Buffer
+	exif.WriteString("II*\x00")
+	binary.Write(&exif, binary.LittleEndian, []uint32{8})
+	binary.Write(&exif, binary.LittleEndian, []uint16{1, 0x0112, 3})
+	binary.Write(&exif, binary.LittleEndian, []uint32{1})
+	binary.Write(&exif, binary.LittleEndian, []uint16{orientation, 0})
+	binary.Write(&exif, binary.LittleEndian, []uint32{0})
+
+	var chunk bytes.Buffer
+	binary.Write(&chunk, binary.BigEndian, uint32(exif.Len()))
+	chunk.WriteString("eXIf")
+	chunk.Write(exif.Bytes())
+	chunk.Write([]byte{0, 0, 0, 0}) // crc is not checked.
+
+	data := buf.Bytes()
+	// insert the chunk after the IHDR chunk: 8 bytes signature and 25 bytes IHDR.
+	return append(append(append([]byte{}, data[:33]...), chunk.Bytes()...), data[33:]...)
+}
+
+func ExampleImageNormalizer() {
+	var normalized bytes.Buffer
+	processed, err := ImageNormalizer{}.Process(
+		"image/png", bytes.NewReader(testPNGWithOrientation(6)), &normalized,
+	)
+	if err != nil {
+		panic(err)
+	}
+	config, _, err := image.DecodeConfig(&normalized)
+	fmt.Println(processed, config.Width, config.Height, err)
+
+	_, err = ImageNormalizer{MaxPixels: 4}.Process(
+		"image/png", bytes.NewReader(testPNGWithOrientation(6)), ioutil.Discard,
+	)
+	fmt.Println(err)
+	// Output:
+	// true 2 4 <nil>
+	// image of 4x2 exceeds 4 pixels
+}
+
+func ExampleBucket_Save_processor() {
+	bucket := *testBucket
+	bucket.Processor = ImageNormalizer{}
+	data := testPNGWithOrientation(8)
+	original, err := getContentHash(bytes.NewReader(data))
+	if err != nil {
+		panic(err)
+	}
+
+	hashes, err := bucket.Save(nil, nil, "normalized", File{IO: bytes.NewReader(data), Size: int64(len(data))})
+	fmt.Println(len(hashes), hashes[0] != original, err)
+	fmt.Println(bucket.Linked(nil, "normalized", original))
+	fmt.Println(bucket.Linked(nil, "normalized", hashes[0]))
+	// Output:
+	// 1 true <nil>
+	// true <nil>
+	// true <nil>
+}
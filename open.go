@@ -5,24 +5,35 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 )
 
 func Open(req *http.Request) ([]byte, error) {
-	q := req.URL.Query()
+	q := DownloadQuery(req)
 	bucket, err := GetBucket(q.Get("b"))
 	if err != nil {
 		return nil, err
 	}
+	if err := bucket.VerifyDownloadURL(req); err != nil {
+		return nil, err
+	}
+	if err := bucket.Authorize(req, q.Get("o"), OpDownload); err != nil {
+		return nil, err
+	}
 	return bucket.ReadFile(nil, q.Get("f"), q.Get("o"))
 }
 
 func GetFile(req *http.Request) (*os.File, error) {
-	q := req.URL.Query()
+	q := DownloadQuery(req)
 	bucket, err := GetBucket(q.Get("b"))
 	if err != nil {
 		return nil, err
 	}
+	if err := bucket.VerifyDownloadURL(req); err != nil {
+		return nil, err
+	}
+	if err := bucket.Authorize(req, q.Get("o"), OpDownload); err != nil {
+		return nil, err
+	}
 	return bucket.GetFile(nil, q.Get("f"), q.Get("o"))
 }
 
@@ -30,19 +41,27 @@ func (b *Bucket) GetFile(db DB, file string, object string) (*os.File, error) {
 	if err := CheckHash(file); err != nil {
 		return nil, err
 	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return nil, err
+	}
 	if object != "" {
 		if err := b.EnsureLinked(db, object, file); err != nil {
 			return nil, err
 		}
 	}
+	if err := b.ensureNotInfected(db, file); err != nil {
+		return nil, err
+	}
 
-	f, err := os.Open(filepath.Join(b.Dir, b.FilePath(file)))
+	f, err := b.openLocalFile(file)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, errors.New("file not exist")
 		}
 		return nil, err
 	}
+	b.recordAccess(file, object)
 	return f, nil
 }
 
@@ -50,13 +69,20 @@ func (b *Bucket) ReadFile(db DB, file string, object string) ([]byte, error) {
 	if err := CheckHash(file); err != nil {
 		return nil, err
 	}
+	file, err := b.resolveAlias(db, file)
+	if err != nil {
+		return nil, err
+	}
 	if object != "" {
 		if err := b.EnsureLinked(db, object, file); err != nil {
 			return nil, err
 		}
 	}
+	if err := b.ensureNotInfected(db, file); err != nil {
+		return nil, err
+	}
 
-	f, err := os.Open(filepath.Join(b.Dir, b.FilePath(file)))
+	f, err := b.openFile(file)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, errors.New("file not exist")
@@ -64,6 +90,7 @@ func (b *Bucket) ReadFile(db DB, file string, object string) ([]byte, error) {
 		return nil, err
 	}
 	defer f.Close()
+	b.recordAccess(file, object)
 
 	return io.ReadAll(f)
 }
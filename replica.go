@@ -0,0 +1,210 @@
+package filestorage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+/*
+openFile opens the local copy of a file. If it's missing, the file is read from other machines by ssh,
+and saved locally by the way if BackfillReplicas is true. The returned reader is an *os.File if the file is local.
+If no machine has the file, the error of opening the local copy is returned.
+*/
+func (b *Bucket) openFile(hash string) (io.ReadCloser, error) {
+	localPath := filepath.Join(b.Dir, b.FilePath(hash))
+	f, err := os.Open(localPath)
+	if err == nil || !os.IsNotExist(err) {
+		return f, err
+	}
+	for _, addr := range b.otherMachines {
+		if r := b.openReplica(addr, hash); r != nil {
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+// openLocalFile opens a file like openFile, but a file read from other machines is saved to
+// a temporary file if it's not backfilled, so that an *os.File is always returned.
+func (b *Bucket) openLocalFile(hash string) (*os.File, error) {
+	r, err := b.openFile(hash)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := r.(*os.File); ok {
+		return f, nil
+	}
+	defer r.Close()
+	if replica, ok := r.(*replicaReader); ok && replica.backfill != nil {
+		if _, err := io.Copy(ioutil.Discard, r); err != nil {
+			return nil, err
+		}
+		// like a copy not matching its hash, which is not saved.
+		if err := replica.backfill.err; err != nil {
+			return nil, err
+		}
+		return os.Open(filepath.Join(b.Dir, b.FilePath(hash)))
+	}
+	temp, err := ioutil.TempFile("", "fs_")
+	if err != nil {
+		return nil, err
+	}
+	// the file is still readable after removed until it's closed.
+	os.Remove(temp.Name())
+	if _, err := io.Copy(temp, r); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	return temp, nil
+}
+
+func (b *Bucket) backfill() bool {
+	return b.BackfillReplicas && b.localMachine
+}
+
+// replicaSSHOptions makes ssh fail fast instead of blocking downloads,
+// if a machine is unreachable or asks for a password.
+var replicaSSHOptions = []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=5"}
+
+// openReplica starts reading a file from a machine, it returns nil if the machine doesn't have the file.
+func (b *Bucket) openReplica(addr, hash string) io.ReadCloser {
+	args := append(append([]string{}, replicaSSHOptions...), addr, "cat", filepath.Join(b.Dir, b.FilePath(hash)))
+	cmd := exec.Command("ssh", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		return nil
+	}
+	r := &replicaReader{cmd: cmd, stdout: stdout, reader: bufio.NewReader(stdout)}
+	// cat fails without output if the file doesn't exist, and an empty file is told by the exit status.
+	if _, err := r.reader.Peek(1); err != nil {
+		stdout.Close()
+		if err != io.EOF || cmd.Wait() != nil {
+			return nil
+		}
+		r.done = true
+	}
+	if b.backfill() {
+		r.backfill = newBackfill(filepath.Join(b.Dir, b.FilePath(hash)), hash)
+		if r.done {
+			r.backfill.commit()
+		}
+	}
+	return r
+}
+
+// replicaReader reads a file from the output of a ssh command.
+// A read error is returned if the command fails before the file is fully read.
+type replicaReader struct {
+	cmd      *exec.Cmd
+	stdout   io.ReadCloser
+	reader   *bufio.Reader
+	backfill *backfill
+	done     bool
+}
+
+func (r *replicaReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 && r.backfill != nil {
+		r.backfill.write(p[:n])
+	}
+	if err == io.EOF {
+		r.done = true
+		if err := r.cmd.Wait(); err != nil {
+			return n, err
+		}
+		if r.backfill != nil {
+			r.backfill.commit()
+		}
+	}
+	return n, err
+}
+
+func (r *replicaReader) Close() error {
+	if !r.done {
+		r.done = true
+		r.stdout.Close()
+		_ = r.cmd.Process.Kill()
+		_ = r.cmd.Wait()
+	}
+	if r.backfill != nil {
+		r.backfill.abort()
+	}
+	return nil
+}
+
+// backfill saves a file read from other machines to the local path, if the file is fully read and
+// its content matches the hash. Errors of backfilling don't break reading, they are kept in err.
+type backfill struct {
+	path, hash string
+	temp       *os.File
+	hasher     hash.Hash
+	err        error
+}
+
+var errReplicaHashMismatch = errors.New("the file read from other machines doesn't match its hash")
+
+func newBackfill(path, hash string) *backfill {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".fs_")
+	if err != nil {
+		return nil
+	}
+	return &backfill{path: path, hash: hash, temp: temp, hasher: sha256.New()}
+}
+
+func (bf *backfill) write(p []byte) {
+	if bf == nil || bf.temp == nil {
+		return
+	}
+	bf.hasher.Write(p)
+	if _, err := bf.temp.Write(p); err != nil {
+		bf.abort()
+		bf.err = err
+	}
+}
+
+func (bf *backfill) commit() {
+	if bf == nil || bf.temp == nil {
+		return
+	}
+	err := bf.temp.Close()
+	if err == nil && base64.RawURLEncoding.EncodeToString(bf.hasher.Sum(nil)) != bf.hash {
+		err = errReplicaHashMismatch
+	}
+	if err == nil {
+		err = os.Rename(bf.temp.Name(), bf.path)
+	}
+	if err != nil {
+		os.Remove(bf.temp.Name())
+	}
+	bf.temp, bf.err = nil, err
+}
+
+func (bf *backfill) abort() {
+	if bf == nil || bf.temp == nil {
+		return
+	}
+	bf.temp.Close()
+	os.Remove(bf.temp.Name())
+	bf.temp = nil
+}
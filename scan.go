@@ -0,0 +1,199 @@
+package filestorage
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+// Scanner scans file content for malware.
+// It returns the malware name if the content is infected, or an empty string if it's clean.
+type Scanner interface {
+	Scan(content io.Reader) (string, error)
+}
+
+// ClamdScanner scans files by a clamd daemon using the INSTREAM command.
+type ClamdScanner struct {
+	Network string // "tcp" or "unix"
+	Address string // "host:port" for tcp, or socket file path for unix.
+	// Timeout of the whole scan, defaults to 1 minute.
+	Timeout time.Duration
+}
+
+const clamdChunkSize = 64 << 10
+
+func (c ClamdScanner) Scan(content io.Reader) (string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	conn, err := net.DialTimeout(c.Network, c.Address, timeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return "", err
+	}
+	// the content is sent in chunks, each is prefixed by its length, and ended by a zero length chunk.
+	var chunk = make([]byte, clamdChunkSize)
+	for {
+		n, err := io.ReadFull(content, chunk)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return "", err
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply parses replies like "stream: OK" or "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (string, error) {
+	result := strings.TrimSpace(reply[strings.LastIndexByte(reply, ':')+1:])
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", errors.New("clamd: " + reply)
+	}
+}
+
+// scan scans file content by Scanner, the file is seeked to start before and after scanning.
+func (b *Bucket) scan(lang string, file io.ReadSeeker) error {
+	if b.Scanner == nil {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	virus, err := b.Scanner.Scan(file)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if virus != "" {
+		return infectedError(lang, virus)
+	}
+	return nil
+}
+
+func infectedError(lang, virus string) error {
+	return localize(lang, "文件包含病毒或恶意软件.", "file is infected by virus or malware.").SetData(virus)
+}
+
+var errInfected = errs.New("forbidden", "file is infected by virus or malware.")
+
+// IsInfected check if an error is the error of downloading an infected file.
+func IsInfected(err error) bool {
+	return err == errInfected
+}
+
+// ensureNotInfected ensures a file is not marked as infected by Rescan.
+func (b *Bucket) ensureNotInfected(db DB, file string) error {
+	row := b.getDB(db).QueryRow(fmt.Sprintf(
+		`SELECT true FROM %s WHERE hash = %s AND infected != ''`, b.FilesTable, quote(file),
+	))
+	var infected bool
+	if err := row.Scan(&infected); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if infected {
+		return errInfected
+	}
+	return nil
+}
+
+/*
+Rescan scans all files in the bucket again by Scanner, it should be called when malware signatures update.
+Infected files are marked in the "infected" column of FilesTable, and they are no longer downloadable.
+Files that are clean now are unmarked. The infected file hashes are returned.
+Rescan must run on one of the Machines, because it reads files from local Dir.
+*/
+func (b *Bucket) Rescan(db DB, logger Logger) ([]string, error) {
+	if b.Scanner == nil {
+		return nil, errors.New("Scanner is nil")
+	}
+	if !b.localMachine {
+		return nil, errors.New("Rescan must run on one of the Machines")
+	}
+	var infected []string
+	var last string
+	for {
+		hashes, err := b.queryFiles(db, fmt.Sprintf(
+			`SELECT hash FROM %s WHERE hash > %s ORDER BY hash LIMIT 1000`, b.FilesTable, quote(last),
+		))
+		if err != nil {
+			return infected, err
+		}
+		if len(hashes) == 0 {
+			return infected, nil
+		}
+		for _, hash := range hashes {
+			virus, err := b.rescanFile(hash)
+			if err != nil {
+				if logger != nil {
+					logger.Error(fmt.Sprintf("rescan %s: %v", hash, err))
+				}
+				continue
+			}
+			if virus != "" {
+				infected = append(infected, hash)
+			}
+			if _, err := b.getDB(db).Exec(fmt.Sprintf(
+				`UPDATE %s SET infected = %s WHERE hash = %s AND infected != %s`,
+				b.FilesTable, quote(virus), quote(hash), quote(virus),
+			)); err != nil {
+				return infected, err
+			}
+		}
+		last = hashes[len(hashes)-1]
+	}
+}
+
+func (b *Bucket) rescanFile(hash string) (string, error) {
+	f, err := os.Open(filepath.Join(b.Dir, b.FilePath(hash)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return b.Scanner.Scan(bufio.NewReader(f))
+}
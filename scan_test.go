@@ -0,0 +1,105 @@
+package filestorage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const testEicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// startTestClamd starts a stand-in clamd daemon, which finds only the EICAR test signature.
+func startTestClamd(network, address string) net.Listener {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestClamd(conn)
+		}
+	}()
+	return listener
+}
+
+func serveTestClamd(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+	var content bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&content, r, int64(size)); err != nil {
+			return
+		}
+	}
+	if strings.Contains(content.String(), testEicar) {
+		conn.Write([]byte("stream: Win.Test.EICAR_HDB-1 FOUND\x00"))
+	} else {
+		conn.Write([]byte("stream: OK\x00"))
+	}
+}
+
+func ExampleClamdScanner() {
+	dir, err := ioutil.TempDir("", "clamd")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "clamd.sock")
+	listener := startTestClamd("unix", socket)
+	defer listener.Close()
+
+	scanner := ClamdScanner{Network: "unix", Address: socket}
+	fmt.Println(scanner.Scan(strings.NewReader("clean content")))
+	fmt.Println(scanner.Scan(strings.NewReader(testEicar)))
+	fmt.Println(scanner.Scan(bytes.NewReader(append(make([]byte, 100<<10), testEicar...))))
+	// Output:
+	//  <nil>
+	// Win.Test.EICAR_HDB-1 <nil>
+	// Win.Test.EICAR_HDB-1 <nil>
+}
+
+func ExampleBucket_Rescan() {
+	listener := startTestClamd("tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	bucket := *testBucket
+	bucket.Scanner = ClamdScanner{Network: "tcp", Address: listener.Addr().String()}
+	_, err := bucket.Save(nil, nil, "", File{IO: strings.NewReader(testEicar), Size: int64(len(testEicar))})
+	fmt.Println(err)
+
+	bucket.Scanner = nil
+	hashes, err := bucket.Save(nil, nil, "", File{IO: strings.NewReader(testEicar), Size: int64(len(testEicar))})
+	fmt.Println(hashes, err)
+
+	bucket.Scanner = ClamdScanner{Network: "tcp", Address: listener.Addr().String()}
+	fmt.Println(bucket.Rescan(nil, nil))
+	_, err = bucket.ReadFile(nil, hashes[0], "")
+	fmt.Println(err)
+	// Output:
+	// args-err: file is infected by virus or malware.
+	// [J1oCG7-2SJ5U1HGJn3250WY_xpXsL-KixFOKq_ZR_Q8] <nil>
+	// [J1oCG7-2SJ5U1HGJn3250WY_xpXsL-KixFOKq_ZR_Q8] <nil>
+	// forbidden: file is infected by virus or malware.
+}
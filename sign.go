@@ -0,0 +1,119 @@
+package filestorage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+var errNoSignKeys = errors.New("SignKeys is empty")
+
+// sign signs data by HMAC-SHA256 with the first key of SignKeys.
+func (b *Bucket) sign(data []byte) (string, error) {
+	if len(b.SignKeys) == 0 {
+		return "", errNoSignKeys
+	}
+	return hmacSign(b.SignKeys[0], data), nil
+}
+
+// verify verifies signature of data with each key of SignKeys, so that keys can be rotated.
+func (b *Bucket) verify(data []byte, signature string) bool {
+	for _, key := range b.SignKeys {
+		if hmac.Equal([]byte(hmacSign(key, data)), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hmacSign(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var errInvalidDownloadURL = errs.New("forbidden", "invalid download url signature")
+var errDownloadURLExpired = errs.New("forbidden", "download url expired")
+
+// signDownloadQuery adds the expiry, binding and signature parameters to the query of a download url.
+func (b *Bucket) signDownloadQuery(q url.Values, options URLOptions) {
+	expiry := options.Expiry
+	if expiry <= 0 {
+		expiry = b.DownloadURLExpiry
+	}
+	q.Set("e", strconv.FormatInt(time.Now().Add(expiry).Unix(), 10))
+	var bind []string
+	if options.ClientIP != "" {
+		bind = append(bind, "ip")
+	}
+	if options.User != "" {
+		bind = append(bind, "user")
+	}
+	if len(bind) > 0 {
+		q.Set("bind", strings.Join(bind, ","))
+	}
+	// Init ensures SignKeys is not empty.
+	signature, _ := b.sign(downloadSignData(q, options.ClientIP, options.User))
+	q.Set("s", signature)
+}
+
+// the client ip and user are signed but not present in urls.
+func downloadSignData(q url.Values, ip, user string) []byte {
+	return []byte(q.Encode() + "\n" + ip + "\n" + user)
+}
+
+// VerifyDownloadURL verifies the signature and expiry of a download request if DownloadURLExpiry > 0.
+func (b *Bucket) VerifyDownloadURL(req *http.Request) error {
+	if b.DownloadURLExpiry <= 0 {
+		return nil
+	}
+	q := DownloadQuery(req)
+	signature := q.Get("s")
+	q.Del("s")
+	var ip, user string
+	for _, bind := range strings.Split(q.Get("bind"), ",") {
+		switch bind {
+		case "ip":
+			ip = ClientIP(req)
+		case "user":
+			if b.UserOf != nil {
+				user = b.UserOf(req)
+			}
+			if user == "" {
+				return errInvalidDownloadURL
+			}
+		}
+	}
+	if !b.verify(downloadSignData(q, ip, user), signature) {
+		return errInvalidDownloadURL
+	}
+	if expires, err := strconv.ParseInt(q.Get("e"), 10, 64); err != nil || time.Now().Unix() > expires {
+		return errDownloadURLExpired
+	}
+	return nil
+}
+
+// ClientIP returns the client ip of a request, the "X-Real-IP" and "X-Forwarded-For" headers set by
+// reverse proxies like nginx are preferred, so they should be overwritten by the proxy in front.
+func ClientIP(req *http.Request) string {
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,33 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func ExampleBucket_VerifyDownloadURL() {
+	bucket := Bucket{
+		Name: "signed", DownloadURLPrefix: "http://example.com/files",
+		SignKeys: []string{"key1"}, DownloadURLExpiry: time.Hour,
+		UserOf: func(req *http.Request) string { return req.Header.Get("User") },
+	}
+	url := bucket.DownloadURL("users.1.avatar", testFile1)
+	fmt.Println(bucket.VerifyDownloadURL(httptest.NewRequest("GET", url, nil)))
+	fmt.Println(bucket.VerifyDownloadURL(httptest.NewRequest("GET", strings.Replace(url, "users.1", "users.2", 1), nil)))
+
+	url = bucket.DownloadURLWithOptions("users.1.avatar", testFile1, URLOptions{ClientIP: "10.0.0.1", User: "u1"})
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("X-Real-IP", "10.0.0.1")
+	req.Header.Set("User", "u1")
+	fmt.Println(bucket.VerifyDownloadURL(req))
+	req.Header.Set("User", "u2")
+	fmt.Println(bucket.VerifyDownloadURL(req))
+	// Output:
+	// <nil>
+	// forbidden: invalid download url signature
+	// <nil>
+	// forbidden: invalid download url signature
+}
@@ -0,0 +1,274 @@
+package filestorage
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SniffInput is the input of a Sniffer.
+type SniffInput struct {
+	Head []byte        // The first 512 bytes at most.
+	File io.ReadSeeker // The whole file, it's seeked to start before calling each sniffer.
+	Size int64
+	Name string // The original file name, maybe empty.
+	// The content type declared by client, maybe empty.
+	// Like Name, it's only a hint and should not be trusted blindly.
+	DeclaredType string
+}
+
+// Sniffer detects content type of a file, it returns an empty string if the file is not recognized.
+type Sniffer func(in SniffInput) string
+
+var sniffers = struct {
+	sync.RWMutex
+	list []Sniffer
+}{list: []Sniffer{sniffISOBMFF, sniffZip, sniffSVG, sniffJSON}}
+
+// RegisterSniffer registers a sniffer, sniffers registered later are called earlier,
+// and all registered sniffers are called earlier than the builtin ones.
+// If no sniffer recognize a file, http.DetectContentType is used.
+func RegisterSniffer(sniffer Sniffer) {
+	sniffers.Lock()
+	defer sniffers.Unlock()
+	sniffers.list = append([]Sniffer{sniffer}, sniffers.list...)
+}
+
+// SniffContentType detects content type of a file.
+// The file name extension and the client declared type are used to refine generic types
+// like "text/plain" or "application/zip", only if they are compatible with the file content.
+func SniffContentType(file io.ReadSeeker, size int64, name, declaredType string) (string, error) {
+	var head [512]byte
+	n, err := io.ReadFull(file, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	in := SniffInput{Head: head[:n], File: file, Size: size, Name: name, DeclaredType: declaredType}
+
+	sniffers.RLock()
+	list := sniffers.list
+	sniffers.RUnlock()
+	var contentType string
+	for _, sniffer := range list {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if contentType = sniffer(in); contentType != "" {
+			break
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if contentType == "" {
+		contentType = refineContentType(http.DetectContentType(in.Head), name, declaredType)
+	}
+	return contentType, nil
+}
+
+var extTypes = map[string]string{
+	".csv":      "text/csv",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".heic":     "image/heic",
+	".heif":     "image/heif",
+	".avif":     "image/avif",
+	".docx":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx":     "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx":     "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+}
+
+// TypeByExtension returns the mime type of a file name extension, like mime.TypeByExtension,
+// but with some common types that may be absent in system mime tables.
+func TypeByExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if typ := extTypes[ext]; typ != "" {
+		return typ
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// extensionByType returns the extension of a mime type, preferring the common ones like ".jpg" and ".txt".
+func extensionByType(contentType string) string {
+	typ := baseMimeType(contentType)
+	switch typ {
+	case "image/jpeg":
+		return ".jpg"
+	case "text/plain":
+		return ".txt"
+	}
+	for ext, t := range extTypes {
+		if t == typ && ext != ".markdown" {
+			return ext
+		}
+	}
+	if exts, _ := mime.ExtensionsByType(typ); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// refineContentType refines a generic detected type by hints compatible with it.
+func refineContentType(detected, name, declaredType string) string {
+	base := baseMimeType(detected)
+	switch base {
+	case "text/plain", "text/xml", "application/zip":
+	default:
+		return detected
+	}
+	for _, hint := range []string{TypeByExtension(filepath.Ext(name)), declaredType} {
+		hint = baseMimeType(hint)
+		if hint == "" || hint == base || !compatibleMimeType(base, hint) {
+			continue
+		}
+		if strings.HasPrefix(hint, "text/") {
+			return hint + "; charset=utf-8"
+		}
+		return hint
+	}
+	return detected
+}
+
+var heifBrands = map[string]string{
+	"heic": "image/heic", "heix": "image/heic", "heim": "image/heic", "heis": "image/heic",
+	"hevc": "image/heic-sequence", "hevx": "image/heic-sequence",
+	"hevm": "image/heic-sequence", "hevs": "image/heic-sequence",
+	"mif1": "image/heif", "msf1": "image/heif-sequence",
+	"avif": "image/avif", "avis": "image/avif",
+}
+
+// sniffISOBMFF detects HEIC/HEIF/AVIF images by brands of the "ftyp" box.
+func sniffISOBMFF(in SniffInput) string {
+	head := in.Head
+	if len(head) < 16 || string(head[4:8]) != "ftyp" {
+		return ""
+	}
+	boxSize := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	if boxSize < 16 || boxSize > len(head) {
+		boxSize = len(head)
+	}
+	// major brand first, then the compatible brands.
+	brands := []string{string(head[8:12])}
+	for i := 16; i+4 <= boxSize; i += 4 {
+		brands = append(brands, string(head[i:i+4]))
+	}
+	for _, brand := range brands {
+		if typ := heifBrands[brand]; typ != "" {
+			return typ
+		}
+	}
+	return ""
+}
+
+var zipMimeTypes = map[string]string{
+	"word/":  "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xl/":    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ppt/":   "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"visio/": "application/vnd.ms-visio.drawing.main+xml",
+}
+
+// sniffZip detects OOXML, OpenDocument, EPUB and JAR files by the zip entries.
+func sniffZip(in SniffInput) string {
+	if !bytes.HasPrefix(in.Head, []byte("PK\x03\x04")) || in.Size <= 0 {
+		return ""
+	}
+	readerAt, ok := in.File.(io.ReaderAt)
+	if !ok {
+		return ""
+	}
+	r, err := zip.NewReader(readerAt, in.Size)
+	if err != nil {
+		return ""
+	}
+	var ooxml, jar bool
+	for _, f := range r.File {
+		switch f.Name {
+		case "[Content_Types].xml":
+			ooxml = true
+		case "META-INF/MANIFEST.MF":
+			jar = true
+		case "mimetype":
+			// OpenDocument and EPUB store the mime type in the "mimetype" entry.
+			if rc, err := f.Open(); err == nil {
+				b, err := ioutil.ReadAll(io.LimitReader(rc, 128))
+				rc.Close()
+				if typ := strings.TrimSpace(string(b)); err == nil && strings.HasPrefix(typ, "application/") {
+					return typ
+				}
+			}
+		}
+	}
+	if ooxml {
+		for _, f := range r.File {
+			if i := strings.IndexByte(f.Name, '/'); i > 0 {
+				if typ := zipMimeTypes[f.Name[:i+1]]; typ != "" {
+					return typ
+				}
+			}
+		}
+	}
+	if jar {
+		return "application/java-archive"
+	}
+	return ""
+}
+
+// sniffSVG detects svg images by the root element of xml or text files.
+func sniffSVG(in SniffInput) string {
+	if !isTextOrXML(in.Head) {
+		return ""
+	}
+	decoder := xml.NewDecoder(io.LimitReader(in.File, 64<<10))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if elem, ok := token.(xml.StartElement); ok {
+			if elem.Name.Local == "svg" {
+				return "image/svg+xml"
+			}
+			return ""
+		}
+	}
+}
+
+// sniffJSON detects json files by parsing the whole file.
+func sniffJSON(in SniffInput) string {
+	trimmed := bytes.TrimLeft(in.Head, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' && trimmed[0] != '[' || !isTextOrXML(in.Head) {
+		return ""
+	}
+	decoder := json.NewDecoder(in.File)
+	var depth int
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF && depth == 0 {
+			return "application/json"
+		} else if err != nil {
+			return ""
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+func isTextOrXML(head []byte) bool {
+	switch baseMimeType(http.DetectContentType(head)) {
+	case "text/plain", "text/xml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package filestorage
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+func ExampleSniffContentType() {
+	var docx bytes.Buffer
+	w := zip.NewWriter(&docx)
+	for _, name := range []string{"[Content_Types].xml", "word/document.xml"} {
+		if f, err := w.Create(name); err != nil {
+			panic(err)
+		} else if _, err := f.Write([]byte("<x/>")); err != nil {
+			panic(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+
+	for _, c := range []struct{ content, name, declaredType string }{
+		{docx.String(), "", ""},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic", "", ""},
+		{`<svg xmlns="http://www.w3.org/2000/svg"></svg>`, "", ""},
+		{` {"a": [1, 2]}`, "", ""},
+		{"a,b\n1,2\n", "a.csv", ""},
+		{"# title\n", "", "text/markdown"},
+		{"alert(1)", "a.html", "text/html"},
+	} {
+		fmt.Println(SniffContentType(
+			strings.NewReader(c.content), int64(len(c.content)), c.name, c.declaredType,
+		))
+	}
+	// Output:
+	// application/vnd.openxmlformats-officedocument.wordprocessingml.document <nil>
+	// image/heic <nil>
+	// image/svg+xml <nil>
+	// application/json <nil>
+	// text/csv; charset=utf-8 <nil>
+	// text/markdown; charset=utf-8 <nil>
+	// text/plain; charset=utf-8 <nil>
+}
+
+func ExampleRegisterSniffer() {
+	RegisterSniffer(func(in SniffInput) string {
+		if bytes.HasPrefix(in.Head, []byte("%custom")) {
+			return "application/x-custom"
+		}
+		return ""
+	})
+	fmt.Println(SniffContentType(strings.NewReader("%custom data"), 12, "", ""))
+	// Output: application/x-custom <nil>
+}
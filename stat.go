@@ -0,0 +1,96 @@
+package filestorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+// FileStat is the metadata of a stored file.
+type FileStat struct {
+	Hash      string    `json:"hash"`
+	Type      string    `json:"type"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+	// The dimensions of images, zero for other files or images stored before dimensions are recorded.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// The count of objects linked to the file.
+	LinkCount int `json:"linkCount"`
+	// The hashes of generated image variants, keyed by variants like "200x200-crop-jpeg-q85".
+	Transformations map[string]string `json:"transformations,omitempty"`
+}
+
+var errFileNotFound = errs.New("not-found", "file not found")
+
+// IsFileNotFound check if an error is the error of Stat on a file not stored.
+func IsFileNotFound(err error) bool {
+	return err == errFileNotFound
+}
+
+// Stat returns the metadata of a file, the hash of an original file processed by Processor is also accepted.
+func (b *Bucket) Stat(db DB, hash string) (*FileStat, error) {
+	stats, err := b.StatMany(db, []string{hash})
+	if err != nil {
+		return nil, err
+	}
+	if stats[0] == nil {
+		return nil, errFileNotFound
+	}
+	return stats[0], nil
+}
+
+// StatMany returns the metadata of files in one query, in the order of hashes, and nil for files not stored.
+func (b *Bucket) StatMany(db DB, hashes []string) ([]*FileStat, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	if err := CheckHash(hashes...); err != nil {
+		return nil, err
+	}
+	resolved, err := b.resolveAliases(db, hashes)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := b.getDB(db).Query(fmt.Sprintf(`
+	SELECT hash, type, size, created_at, width, height, tranformations,
+		(SELECT count(*) FROM %s WHERE file = f.hash)
+	FROM %s f
+	WHERE hash IN (%s)
+	`, b.LinksTable, b.FilesTable, quoteList(resolved),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var m = make(map[string]*FileStat)
+	for rows.Next() {
+		var stat FileStat
+		var transformations []byte
+		if err := rows.Scan(
+			&stat.Hash, &stat.Type, &stat.Size, &stat.CreatedAt, &stat.Width, &stat.Height,
+			&transformations, &stat.LinkCount,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(transformations, &stat.Transformations); err != nil {
+			return nil, err
+		}
+		if len(stat.Transformations) == 0 {
+			stat.Transformations = nil
+		}
+		m[stat.Hash] = &stat
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var stats = make([]*FileStat, len(resolved))
+	for i, hash := range resolved {
+		stats[i] = m[hash]
+	}
+	return stats, nil
+}
@@ -0,0 +1,39 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http/httptest"
+)
+
+func ExampleBucket_StatMany() {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 60, 40))); err != nil {
+		panic(err)
+	}
+	hashes, err := testBucket.Save(nil, nil, "stat", File{IO: bytes.NewReader(buf.Bytes()), Size: int64(buf.Len())})
+	if err != nil {
+		panic(err)
+	}
+	if err := testBucket.Link(nil, "stat2", hashes[0]); err != nil {
+		panic(err)
+	}
+	variant := Variant{Width: 30, Format: "png"}
+	if err := testBucket.DownloadVariant(nil, httptest.NewRecorder(), hashes[0], "", variant); err != nil {
+		panic(err)
+	}
+
+	stats, err := testBucket.StatMany(nil, []string{hashes[0], "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF9"})
+	fmt.Println(len(stats), stats[1], err)
+	stat := stats[0]
+	fmt.Println(stat.Type, stat.Width, stat.Height, stat.LinkCount, len(stat.Transformations))
+
+	variantStat, err := testBucket.Stat(nil, stat.Transformations["30x0-png"])
+	fmt.Println(variantStat.Type, variantStat.Width, variantStat.Height, variantStat.LinkCount, err)
+	// Output:
+	// 2 <nil> <nil>
+	// image/png 60 40 2 1
+	// image/png 30 20 0 <nil>
+}
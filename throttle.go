@@ -0,0 +1,192 @@
+package filestorage
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+var errTooManyDownloads = errs.New("too-many-downloads", "too many concurrent downloads")
+
+// IsTooManyDownloads check if an error is the error of a download rejected by MaxDownloads.
+func IsTooManyDownloads(err error) bool {
+	return err == errTooManyDownloads
+}
+
+// throttle limits the bandwidth and concurrency of files sent directly by a bucket.
+type throttle struct {
+	streams chan struct{}
+	bucket  *rateLimiter
+
+	mutex   sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	*rateLimiter
+	streams int
+}
+
+var throttleMutex sync.Mutex
+
+func (b *Bucket) getThrottle() *throttle {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+	if b.throttle == nil {
+		b.throttle = &throttle{clients: make(map[string]*clientLimiter)}
+		if b.MaxDownloads > 0 {
+			b.throttle.streams = make(chan struct{}, b.MaxDownloads)
+		}
+		if b.RateLimit > 0 {
+			b.throttle.bucket = newRateLimiter(b.RateLimit)
+		}
+	}
+	return b.throttle
+}
+
+/*
+throttled returns a writer to send a file to resp, which is limited by RateLimit and ClientRateLimit.
+If MaxDownloads is reached, 503 is responded and errTooManyDownloads is returned.
+The returned release function must be called after the file is sent.
+*/
+func (b *Bucket) throttled(resp http.ResponseWriter, req *http.Request) (http.ResponseWriter, func(), error) {
+	if b.MaxDownloads <= 0 && b.RateLimit <= 0 && b.ClientRateLimit <= 0 {
+		return resp, func() {}, nil
+	}
+	t := b.getThrottle()
+	if t.streams != nil {
+		select {
+		case t.streams <- struct{}{}:
+		default:
+			resp.Header().Set("Retry-After", "1")
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			return nil, nil, errTooManyDownloads
+		}
+	}
+	var limiters []*rateLimiter
+	if t.bucket != nil {
+		limiters = append(limiters, t.bucket)
+	}
+	var client string
+	if b.ClientRateLimit > 0 && req != nil {
+		client = ClientIP(req)
+		limiters = append(limiters, t.acquireClient(client, b.ClientRateLimit))
+	}
+	release := func() {
+		if client != "" {
+			t.releaseClient(client)
+		}
+		if t.streams != nil {
+			<-t.streams
+		}
+	}
+	if len(limiters) == 0 {
+		return resp, release, nil
+	}
+	return &throttledWriter{ResponseWriter: resp, limiters: limiters}, release, nil
+}
+
+// acquireClient returns the limiter of a client, which is shared by all downloads of the client.
+func (t *throttle) acquireClient(client string, rate int64) *rateLimiter {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	limiter := t.clients[client]
+	if limiter == nil {
+		limiter = &clientLimiter{rateLimiter: newRateLimiter(rate)}
+		t.clients[client] = limiter
+	}
+	limiter.streams++
+	return limiter.rateLimiter
+}
+
+// releaseClient removes the limiter of a client if it has no downloads.
+func (t *throttle) releaseClient(client string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if limiter := t.clients[client]; limiter != nil {
+		if limiter.streams--; limiter.streams <= 0 {
+			delete(t.clients, client)
+		}
+	}
+}
+
+// throttledWriter writes at most one burst of the limiters at a time, and waits for their tokens.
+type throttledWriter struct {
+	http.ResponseWriter
+	limiters []*rateLimiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		n := len(p)
+		for _, l := range w.limiters {
+			if burst := l.burst(); n > burst {
+				n = burst
+			}
+		}
+		var delay time.Duration
+		for _, l := range w.limiters {
+			if d := l.reserve(n); d > delay {
+				delay = d
+			}
+		}
+		time.Sleep(delay)
+		m, err := w.ResponseWriter.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom hides the ReadFrom method of the ResponseWriter, so that io.Copy calls Write.
+func (w *throttledWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// rateLimiter is a token bucket of bytes, which is filled at rate bytes per second, up to one second.
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+// burst returns the max bytes to write at a time, so that tokens are reserved smoothly.
+func (l *rateLimiter) burst() int {
+	const maxBurst = 32 << 10
+	if l.rate < maxBurst {
+		if l.rate < 1 {
+			return 1
+		}
+		return int(l.rate)
+	}
+	return maxBurst
+}
+
+// reserve takes n tokens, and returns how long to wait until the tokens are available.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
@@ -0,0 +1,52 @@
+package filestorage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func ExampleDirectSend() {
+	dir, err := ioutil.TempDir("", "throttle")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	hash := "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1"
+	if err := ioutil.WriteFile(filepath.Join(dir, hash), make([]byte, 150<<10), 0644); err != nil {
+		panic(err)
+	}
+	bucket := &Bucket{Dir: dir, RateLimit: 100 << 10, MaxDownloads: 1}
+	file := &DeliveryFile{
+		Hash: hash, Dir: dir, Path: hash, ETag: `"` + hash + `"`,
+		CacheControl: "public, max-age=31536000, immutable", bucket: bucket,
+	}
+
+	start := time.Now()
+	resp := httptest.NewRecorder()
+	fmt.Println(DirectSend{}.Deliver(resp, httptest.NewRequest("GET", "/", nil), file))
+	fmt.Println(resp.Body.Len(), time.Since(start) >= 400*time.Millisecond)
+
+	_, release, _ := bucket.throttled(httptest.NewRecorder(), nil)
+	resp = httptest.NewRecorder()
+	fmt.Println(DirectSend{}.Deliver(resp, nil, file))
+	// error responses are not cached.
+	fmt.Println(resp.Code, resp.Header().Get("Retry-After"), resp.Header().Get("Cache-Control") == "")
+	release()
+
+	resp = httptest.NewRecorder()
+	fmt.Println(DirectSend{}.Deliver(resp, nil, &DeliveryFile{
+		Hash: "TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF2", Dir: dir, CacheControl: file.CacheControl, bucket: bucket,
+	}))
+	fmt.Println(resp.Code, resp.Header().Get("Cache-Control") == "")
+	// Output:
+	// <nil>
+	// 153600 true
+	// too-many-downloads: too many concurrent downloads
+	// 503 1 true
+	// <nil>
+	// 404 true
+}
@@ -0,0 +1,401 @@
+package filestorage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,termination,expiration"
+)
+
+var errUploadNotFound = errs.New("not-found", "upload session not found")
+var errUploadOffset = errs.New("args-err", "Upload-Offset mismatch")
+
+func (b *Bucket) createUploadSessionsTable(db DB) error {
+	if b.UploadSessionsTable == "" {
+		b.UploadSessionsTable = "file_upload_sessions"
+	}
+	_, err := b.getDB(db).Exec(fmt.Sprintf(`
+	CREATE TABLE IF NOT EXISTS %s (
+		id         text        NOT NULL UNIQUE,
+		length     int8        NOT NULL,
+		uploaded   int8        NOT NULL DEFAULT 0,
+		name       text        NOT NULL DEFAULT '',
+		object     text        NOT NULL DEFAULT '',
+		file       text        NOT NULL DEFAULT '',
+		created_at timestamptz NOT NULL,
+		expires_at timestamptz NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS %s_expires_at_index ON %s(expires_at);
+	`, b.UploadSessionsTable, b.UploadSessionsTable, b.UploadSessionsTable,
+	))
+	return err
+}
+
+type uploadSession struct {
+	ID        string
+	Length    int64
+	Uploaded  int64
+	Name      string
+	Object    string
+	File      string
+	ExpiresAt time.Time
+}
+
+/*
+TusHandler implements the tus resumable upload protocol(https://tus.io/protocols/resumable-upload.html),
+with the creation, creation-with-upload, termination and expiration extensions.
+
+The uploaded bytes are appended to a partial file under "Dir/.uploads" on the machine that serves the request,
+so requests of the same upload must be routed to the same machine.
+When an upload is completed, it's saved into the bucket like Bucket.Save, and the file hash is sent in the
+"File-Hash" response header. The "filename" and "linkObject" keys of "Upload-Metadata" are recognized,
+if "linkObject" is present, the file is linked to it.
+*/
+type TusHandler struct {
+	Bucket *Bucket
+	// The url path that the handler is mounted at, it's used to make the "Location" header.
+	BasePath string
+	MaxSize  int64
+	Checker  FileChecker
+	// How long an upload session is kept since created, defaults to 24 hours.
+	// Expired sessions and their partial files are cleaned by StartClean.
+	Expires time.Duration
+	Logger  Logger
+}
+
+func (h *TusHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Tus-Resumable", tusVersion)
+	if req.Method == http.MethodOptions {
+		resp.Header().Set("Tus-Version", tusVersion)
+		resp.Header().Set("Tus-Extension", tusExtensions)
+		if h.MaxSize > 0 {
+			resp.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+		}
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if req.Header.Get("Tus-Resumable") != tusVersion {
+		resp.Header().Set("Tus-Version", tusVersion)
+		resp.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	var err error
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, h.BasePath), "/")
+	switch {
+	case req.Method == http.MethodPost && id == "":
+		err = h.create(resp, req)
+	case req.Method == http.MethodHead && id != "":
+		err = h.head(resp, id)
+	case req.Method == http.MethodPatch && id != "":
+		err = h.patch(resp, req, id)
+	case req.Method == http.MethodDelete && id != "":
+		err = h.terminate(resp, id)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		h.writeError(resp, err)
+	}
+}
+
+func (h *TusHandler) create(resp http.ResponseWriter, req *http.Request) error {
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return errs.New("args-err", "invalid Upload-Length")
+	}
+	if h.MaxSize > 0 && length > h.MaxSize {
+		resp.WriteHeader(http.StatusRequestEntityTooLarge)
+		return nil
+	}
+	metadata := parseTusMetadata(req.Header.Get("Upload-Metadata"))
+	if err := h.Bucket.Authorize(req, metadata["linkObject"], OpUploadLink); err != nil {
+		return err
+	}
+	id, err := newUploadID()
+	if err != nil {
+		return err
+	}
+	expires := h.Expires
+	if expires <= 0 {
+		expires = 24 * time.Hour
+	}
+	session := uploadSession{
+		ID: id, Length: length, Name: metadata["filename"], Object: metadata["linkObject"],
+		ExpiresAt: time.Now().Add(expires),
+	}
+	if err := h.Bucket.createUploadSession(session); err != nil {
+		return err
+	}
+	if err := h.Bucket.writeFile(strings.NewReader(""), h.Bucket.uploadPath(id)); err != nil {
+		return err
+	}
+
+	resp.Header().Set("Location", path.Join(h.BasePath, id))
+	resp.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	if req.ContentLength != 0 && req.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		if err := h.upload(resp, req, id, 0); err != nil {
+			return err
+		}
+	}
+	resp.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (h *TusHandler) head(resp http.ResponseWriter, id string) error {
+	session, err := h.Bucket.getUploadSession(h.Bucket.DB, id, false)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Header().Set("Upload-Offset", strconv.FormatInt(session.Uploaded, 10))
+	resp.Header().Set("Upload-Length", strconv.FormatInt(session.Length, 10))
+	resp.Header().Set("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	if session.File != "" {
+		resp.Header().Set("File-Hash", session.File)
+	}
+	resp.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *TusHandler) patch(resp http.ResponseWriter, req *http.Request, id string) error {
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		resp.WriteHeader(http.StatusUnsupportedMediaType)
+		return nil
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return errs.New("args-err", "invalid Upload-Offset")
+	}
+	if err := h.upload(resp, req, id, offset); err != nil {
+		return err
+	}
+	resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// upload appends request body to the partial file, and save it into the bucket if completed.
+func (h *TusHandler) upload(resp http.ResponseWriter, req *http.Request, id string, offset int64) error {
+	b := h.Bucket
+	var session uploadSession
+	var copyErr error
+	if err := runInTx(b.DB, func(tx DB) error {
+		var err error
+		if session, err = b.getUploadSession(tx, id, true); err != nil {
+			return err
+		}
+		if session.Uploaded != offset {
+			return errUploadOffset
+		}
+		if session.File != "" {
+			return nil
+		}
+		// the received bytes are kept even if the request is broken, so the client can resume from them.
+		var n int64
+		n, copyErr = b.appendUpload(id, offset, io.LimitReader(req.Body, session.Length-offset))
+		if n == 0 {
+			return nil
+		}
+		session.Uploaded += n
+		_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET uploaded = %d WHERE id = %s`,
+			b.UploadSessionsTable, session.Uploaded, quote(id),
+		))
+		return err
+	}); err != nil {
+		return err
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+	if session.File == "" && session.Uploaded == session.Length {
+		if err := h.complete(&session); err != nil {
+			return err
+		}
+	}
+	resp.Header().Set("Upload-Offset", strconv.FormatInt(session.Uploaded, 10))
+	if session.File != "" {
+		resp.Header().Set("File-Hash", session.File)
+	}
+	return nil
+}
+
+func (h *TusHandler) complete(session *uploadSession) error {
+	b := h.Bucket
+	f, err := os.Open(b.uploadPath(session.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hashes, err := b.SaveWithChecker(b.DB, h.Checker, session.Object, File{IO: f, Name: session.Name, Size: session.Length})
+	if err != nil {
+		// the upload can never pass the check, so it's useless to keep it.
+		if e, ok := err.(*errs.Error); ok && e.Code() == "args-err" {
+			_ = b.deleteUploadSession(session.ID)
+		}
+		return err
+	}
+	session.File = hashes[0]
+	if _, err := b.DB.Exec(fmt.Sprintf(`UPDATE %s SET file = %s WHERE id = %s`,
+		b.UploadSessionsTable, quote(session.File), quote(session.ID),
+	)); err != nil {
+		return err
+	}
+	return os.Remove(b.uploadPath(session.ID))
+}
+
+func (h *TusHandler) terminate(resp http.ResponseWriter, id string) error {
+	if err := h.Bucket.deleteUploadSession(id); err != nil {
+		return err
+	}
+	resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *TusHandler) writeError(resp http.ResponseWriter, err error) {
+	var status = http.StatusInternalServerError
+	if e, ok := err.(*errs.Error); ok {
+		switch e.Code() {
+		case "not-found":
+			status = http.StatusNotFound
+		case "forbidden":
+			status = http.StatusForbidden
+		case "args-err":
+			status = http.StatusBadRequest
+			if err == errUploadOffset {
+				status = http.StatusConflict
+			}
+		}
+	}
+	if status == http.StatusInternalServerError && h.Logger != nil {
+		h.Logger.Error(err)
+	}
+	http.Error(resp, err.Error(), status)
+}
+
+func (b *Bucket) createUploadSession(session uploadSession) error {
+	_, err := b.DB.Exec(fmt.Sprintf(`
+	INSERT INTO %s (id, length, name, object, created_at, expires_at)
+	VALUES (%s, %d, %s, %s, %s, %s)
+	`, b.UploadSessionsTable, quote(session.ID), session.Length, quote(session.Name),
+		quote(session.Object), fmtTime(time.Now()), fmtTime(session.ExpiresAt),
+	))
+	return err
+}
+
+func (b *Bucket) deleteUploadSession(id string) error {
+	result, err := b.DB.Exec(fmt.Sprintf(
+		`DELETE FROM %s WHERE id = %s`, b.UploadSessionsTable, quote(id),
+	))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errUploadNotFound
+	}
+	if err := os.Remove(b.uploadPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (b *Bucket) getUploadSession(db DB, id string, forUpdate bool) (uploadSession, error) {
+	var lock string
+	if forUpdate {
+		lock = "FOR UPDATE"
+	}
+	row := db.QueryRow(fmt.Sprintf(`
+	SELECT id, length, uploaded, name, object, file, expires_at
+	FROM %s WHERE id = %s AND expires_at > %s %s
+	`, b.UploadSessionsTable, quote(id), fmtTime(time.Now()), lock,
+	))
+	var s uploadSession
+	if err := row.Scan(&s.ID, &s.Length, &s.Uploaded, &s.Name, &s.Object, &s.File, &s.ExpiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return s, errUploadNotFound
+		}
+		return s, err
+	}
+	return s, nil
+}
+
+func (b *Bucket) appendUpload(id string, offset int64, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(b.uploadPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, errUploadNotFound
+		}
+		return 0, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil {
+		return 0, err
+	} else if info.Size() != offset {
+		return 0, errUploadOffset
+	}
+	return io.Copy(f, body)
+}
+
+// cleanUploads deletes expired upload sessions and their partial files.
+func (b *Bucket) cleanUploads(tx DB) error {
+	ids, err := b.queryFiles(tx, fmt.Sprintf(`
+	DELETE FROM %s WHERE expires_at < %s RETURNING id
+	`, b.UploadSessionsTable, fmtTime(time.Now()),
+	))
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	var paths []string
+	for _, id := range ids {
+		paths = append(paths, filepath.Join(uploadsDir, id))
+	}
+	return b.runScript(fmt.Sprintf(`cd %s; rm -f %s`, b.Dir, strings.Join(paths, " ")))
+}
+
+func (b *Bucket) uploadPath(id string) string {
+	return filepath.Join(b.Dir, uploadsDir, id)
+}
+
+func newUploadID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(id[:]), nil
+}
+
+// parseTusMetadata parses the "Upload-Metadata" header: comma separated "key base64(value)" pairs.
+func parseTusMetadata(header string) map[string]string {
+	var metadata = make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value string
+		if len(fields) > 1 {
+			if v, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+				value = string(v)
+			}
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata
+}
@@ -0,0 +1,61 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+func ExampleTusHandler() {
+	server := httptest.NewServer(&TusHandler{Bucket: testBucket, BasePath: "/uploads"})
+	defer server.Close()
+
+	send := func(method, path, body string, headers ...string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			panic(err)
+		}
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			panic(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := send("OPTIONS", "/uploads", "")
+	fmt.Println(resp.StatusCode, resp.Header.Get("Tus-Extension"))
+
+	resp = send("POST", "/uploads", "", "Upload-Length", "16", "Upload-Metadata", "filename dHVzLnR4dA==")
+	fmt.Println(resp.StatusCode)
+	location := resp.Header.Get("Location")
+
+	const contentType = "application/offset+octet-stream"
+	resp = send("PATCH", location, "hello ", "Upload-Offset", "0", "Content-Type", contentType)
+	fmt.Println(resp.StatusCode, resp.Header.Get("Upload-Offset"))
+
+	resp = send("PATCH", location, "tus upload", "Upload-Offset", "0", "Content-Type", contentType)
+	fmt.Println(resp.StatusCode)
+
+	resp = send("HEAD", location, "")
+	fmt.Println(resp.StatusCode, resp.Header.Get("Upload-Offset"), resp.Header.Get("Upload-Length"))
+
+	resp = send("PATCH", location, "tus upload", "Upload-Offset", "6", "Content-Type", contentType)
+	fmt.Println(resp.StatusCode, resp.Header.Get("Upload-Offset"), resp.Header.Get("File-Hash"))
+
+	resp = send("DELETE", location, "")
+	fmt.Println(resp.StatusCode)
+	// Output:
+	// 204 creation,creation-with-upload,termination,expiration
+	// 201
+	// 204 6
+	// 409
+	// 200 6 16
+	// 204 16 lnrWtvd7ys4TFAKqh9GYLVj73P5umF0MNafMUl_oz-c
+	// 204
+}
@@ -10,18 +10,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 
-	"github.com/dustin/go-humanize"
 	"github.com/lovego/addrs"
 	"github.com/lovego/errs"
-	"golang.org/x/text/language"
-	"golang.org/x/text/message"
 )
 
 const (
 	defaultMaxSize int64 = 2 * (1 << 20)
 	readSize       int64 = 10 * (1 << 20)
+	// the dir under Dir to store files being uploaded.
+	uploadsDir = ".uploads"
 )
 
 func UploadImages(req *http.Request, lang string) ([]string, error) {
@@ -37,7 +35,31 @@ func UploadWithMaxSize(req *http.Request, lang string, maxSize int64) ([]string,
 	return bucket.UploadDefault(req, lang, maxSize)
 }
 
+// UploadDefault upload files in the "file" field of a multipart form, only images not larger than maxSize
+// are allowed. If the "linkObject" query parameter is present, the files are linked to it.
 func (b *Bucket) UploadDefault(req *http.Request, lang string, maxSize int64) ([]string, error) {
+	return b.UploadWithPolicy(req, lang, "", maxSize)
+}
+
+// UploadWithPolicy upload files like UploadDefault, but check files by the named policy registered in
+// Policies. The policy is chosen by the server, it should never come from request parameters,
+// otherwise a client can choose a permissive policy. If policy is empty, it's the same as UploadDefault.
+func (b *Bucket) UploadWithPolicy(req *http.Request, lang, policy string, maxSize int64) ([]string, error) {
+	q := req.URL.Query()
+	if err := b.Authorize(req, q.Get("linkObject"), OpUploadLink); err != nil {
+		return nil, err
+	}
+	checker, err := b.uploadChecker(policy, lang, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return b.uploadForm(req, checker, q.Get("linkObject"), maxSize)
+}
+
+// uploadForm upload files in the "file" field of a multipart form.
+func (b *Bucket) uploadForm(
+	req *http.Request, checker FileChecker, object string, maxSize int64,
+) ([]string, error) {
 	var size = readSize
 	if maxSize > readSize {
 		size = maxSize
@@ -49,13 +71,19 @@ func (b *Bucket) UploadDefault(req *http.Request, lang string, maxSize int64) ([
 	if len(files) == 0 {
 		return nil, errs.New("args-err", "no files")
 	}
-	q := req.URL.Query()
-	return b.Upload(nil, imageChecker{lang, maxSize}.Check, q.Get("linkObject"), files...)
+	return b.UploadWithChecker(nil, checker, object, files...)
 }
 
 // Upload files, if object is not empty, the files are linked to it.
 func (b *Bucket) Upload(
 	db DB, fileCheck func(string, int64) error, object string, fileHeaders ...*multipart.FileHeader,
+) ([]string, error) {
+	return b.UploadWithChecker(db, funcChecker(fileCheck), object, fileHeaders...)
+}
+
+// UploadWithChecker upload files like Upload, but check files by a FileChecker.
+func (b *Bucket) UploadWithChecker(
+	db DB, checker FileChecker, object string, fileHeaders ...*multipart.FileHeader,
 ) ([]string, error) {
 	var files = make([]File, len(fileHeaders))
 	for i := range fileHeaders {
@@ -65,14 +93,23 @@ func (b *Bucket) Upload(
 		}
 		defer f.Close()
 		files[i].IO = f
+		files[i].Name = fileHeaders[i].Filename
+		files[i].Type = fileHeaders[i].Header.Get("Content-Type")
 		files[i].Size = fileHeaders[i].Size
 	}
-	return b.Save(db, fileCheck, object, files...)
+	return b.SaveWithChecker(db, checker, object, files...)
 }
 
 // SaveFiles save files specified by paths into bucket.
 func (b *Bucket) SaveFiles(
 	db DB, fileCheck func(string, int64) error, object string, paths ...string,
+) (fileHashes []string, err error) {
+	return b.SaveFilesWithChecker(db, funcChecker(fileCheck), object, paths...)
+}
+
+// SaveFilesWithChecker save files like SaveFiles, but check files by a FileChecker.
+func (b *Bucket) SaveFilesWithChecker(
+	db DB, checker FileChecker, object string, paths ...string,
 ) (fileHashes []string, err error) {
 	var files = make([]File, len(paths))
 	for i := range paths {
@@ -82,6 +119,7 @@ func (b *Bucket) SaveFiles(
 		}
 		defer f.Close()
 		files[i].IO = f
+		files[i].Name = filepath.Base(paths[i])
 
 		info, err := os.Stat(paths[i])
 		if err != nil {
@@ -90,24 +128,33 @@ func (b *Bucket) SaveFiles(
 		files[i].Size = info.Size()
 	}
 
-	return b.Save(db, fileCheck, object, files...)
+	return b.SaveWithChecker(db, checker, object, files...)
 }
 
 // File reprents the file to store.
 type File struct {
 	IO   io.ReadSeeker // bytes.Reader and strings.Reader implemented this interface.
+	Name string        // The original file name, optional.
+	Type string        // The content type declared by client, optional, it's only a hint.
 	Size int64
 }
 
 // Save save files into bucket.
 func (b *Bucket) Save(
 	db DB, fileCheck func(string, int64) error, object string, files ...File,
+) (fileHashes []string, err error) {
+	return b.SaveWithChecker(db, funcChecker(fileCheck), object, files...)
+}
+
+// SaveWithChecker save files like Save, but check files by a FileChecker.
+func (b *Bucket) SaveWithChecker(
+	db DB, checker FileChecker, object string, files ...File,
 ) (fileHashes []string, err error) {
 	if len(files) == 0 {
 		return nil, nil
 	}
 	err = runInTx(db, func(tx DB) error {
-		hashes, err := b.save(tx, fileCheck, object, files)
+		hashes, err := b.save(tx, checker, object, files)
 		if err != nil {
 			return err
 		}
@@ -118,18 +165,22 @@ func (b *Bucket) Save(
 }
 
 func (b *Bucket) save(
-	db DB, fileCheck func(string, int64) error, object string, files []File,
+	db DB, checker FileChecker, object string, files []File,
 ) ([]string, error) {
-	records, err := b.createFileRecords(db, files, fileCheck)
+	records, temps, err := b.createFileRecords(db, files, checker)
+	for _, temp := range temps {
+		defer temp.Close()
+	}
 	if err != nil {
 		return nil, err
 	}
-	var hashes []string
+	var hashes, names []string
 	for i := range records {
 		hashes = append(hashes, records[i].Hash)
+		names = append(names, records[i].Name)
 	}
 	if object != "" {
-		if err := b.Link(db, object, hashes...); err != nil {
+		if err := b.linkNamed(db, object, hashes, names); err != nil {
 			return nil, err
 		}
 	}
@@ -156,6 +207,10 @@ func (b *Bucket) saveFile(file io.Reader, hash string) error {
 		}
 		srcPath = tempFile
 	}
+	return b.copyToOthers(srcPath, destPath)
+}
+
+func (b *Bucket) copyToOthers(srcPath, destPath string) error {
 	for _, addr := range b.otherMachines {
 		if err := exec.Command("ssh", addr, "mkdir", "-p", filepath.Dir(destPath)).Run(); err != nil {
 			return err
@@ -253,44 +308,3 @@ func runInTx(db DB, work func(DB) error) error {
 	}
 	return work(db)
 }
-
-type imageChecker struct {
-	lang    string
-	maxSize int64
-}
-
-func (img imageChecker) Check(contentType string, size int64) error {
-	if !strings.HasPrefix(contentType, "image/") {
-		return img.fileTypeError(contentType)
-	}
-	if img.maxSize <= 0 {
-		img.maxSize = defaultMaxSize
-	}
-
-	if size > img.maxSize {
-		return img.fileSizeError(size)
-	}
-	return nil
-}
-
-func (img imageChecker) fileTypeError(typ string) error {
-	switch img.lang {
-	case "zh", "cn":
-		return errs.New("args-err", "文件类型不是图片.").SetData(typ)
-	default:
-		return errs.New("args-err", "file type is not an image.").SetData(typ)
-	}
-}
-
-var printer = message.NewPrinter(language.English)
-
-func (img imageChecker) fileSizeError(size int64) error {
-	s := printer.Sprintf("%d", size)
-	msg := humanize.IBytes(uint64(img.maxSize))
-	switch img.lang {
-	case "zh", "cn":
-		return errs.Newf("args-err", "文件大小不能超过%s.", msg).SetData(s)
-	default:
-		return errs.Newf("args-err", "file size cann't exceed %s.", msg).SetData(s)
-	}
-}
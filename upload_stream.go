@@ -0,0 +1,230 @@
+package filestorage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/lovego/errs"
+)
+
+// UploadStream upload files like UploadWithMaxSize, but without buffering whole files.
+func UploadStream(req *http.Request, lang string, maxSize int64) ([]string, error) {
+	q := req.URL.Query()
+	bucket, err := GetBucket(q.Get("bucket"))
+	if err != nil {
+		return nil, err
+	}
+	return bucket.UploadStream(req, lang, maxSize)
+}
+
+// UploadStream upload files like UploadDefault, but walks the multipart parts directly.
+// Each file part is written straight into the bucket while hashing, and the size limit of the checker
+// is enforced while streaming, so memory use is constant regardless of file size.
+func (b *Bucket) UploadStream(req *http.Request, lang string, maxSize int64) ([]string, error) {
+	if err := b.Authorize(req, req.URL.Query().Get("linkObject"), OpUploadLink); err != nil {
+		return nil, err
+	}
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	checker, err := b.uploadChecker("", lang, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if sizer, ok := checker.(interface{ MaxSize() int64 }); ok {
+		maxSize = sizer.MaxSize()
+	}
+
+	var files []streamedFile
+	defer func() {
+		for _, file := range files {
+			_ = os.Remove(file.Path)
+		}
+	}()
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+		file, err := b.receivePart(part, checker, lang, maxSize)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, errs.New("args-err", "no files")
+	}
+	return b.saveStreamed(nil, req.URL.Query().Get("linkObject"), files)
+}
+
+type streamedFile struct {
+	fileRecord
+	Path string // the temporary file path under Dir.
+}
+
+// receivePart writes a file part into a temporary file under Dir, and compute its type and hash.
+// If maxSize > 0, reading stops as soon as the part exceeds it.
+func (b *Bucket) receivePart(
+	part *multipart.Part, checker FileChecker, lang string, maxSize int64,
+) (file streamedFile, err error) {
+	dir := filepath.Join(b.Dir, uploadsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return file, err
+	}
+	temp, err := ioutil.TempFile(dir, "part_")
+	if err != nil {
+		return file, err
+	}
+	defer temp.Close()
+	file.Path = temp.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(file.Path)
+		}
+	}()
+
+	var reader io.Reader = part
+	if maxSize > 0 {
+		// read one more byte than the limit to know if the limit is exceeded.
+		reader = io.LimitReader(part, maxSize+1)
+	}
+	h := sha256.New()
+	if file.Size, err = io.Copy(io.MultiWriter(temp, h), reader); err != nil {
+		return file, err
+	}
+	if maxSize > 0 && file.Size > maxSize {
+		return file, fileSizeError(lang, maxSize, file.Size)
+	}
+	if file.Size == 0 {
+		return file, errs.New("args-err", "empty file")
+	}
+	file.Hash = base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+	file.Name = part.FileName()
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return file, err
+	}
+	if file.Type, err = SniffContentType(
+		temp, file.Size, part.FileName(), part.Header.Get("Content-Type"),
+	); err != nil {
+		return file, err
+	}
+	if sanitized, err := b.handleActiveContent(lang, file.Type, temp); err != nil {
+		return file, err
+	} else if sanitized != nil {
+		if file, err = rewriteTempFile(temp, sanitized, file); err != nil {
+			return file, err
+		}
+	}
+	// files are checked before processed, so oversize images are rejected before decoded.
+	if err := check(checker, FileInfo{Name: part.FileName(), Type: file.Type, Size: file.Size, IO: temp}); err != nil {
+		return file, err
+	}
+	if processed, err := b.process(lang, file.Type, temp); err != nil {
+		return file, err
+	} else if processed != nil {
+		defer processed.Close()
+		file.Alias = file.Hash
+		if file, err = rewriteTempFile(temp, processed, file); err != nil {
+			return file, err
+		}
+		if file.Type, err = SniffContentType(
+			temp, file.Size, part.FileName(), part.Header.Get("Content-Type"),
+		); err != nil {
+			return file, err
+		}
+		if err := check(checker, FileInfo{
+			Name: part.FileName(), Type: file.Type, Size: file.Size, IO: temp,
+		}); err != nil {
+			return file, err
+		}
+	}
+	if err := b.scan(lang, temp); err != nil {
+		return file, err
+	}
+	file.Width, file.Height, err = imageSize(file.Type, temp)
+	return file, err
+}
+
+// rewriteTempFile replaces the content of a temporary file, and recompute its size and hash.
+func rewriteTempFile(temp *os.File, content io.Reader, file streamedFile) (streamedFile, error) {
+	if err := temp.Truncate(0); err != nil {
+		return file, err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return file, err
+	}
+	size, err := io.Copy(temp, content)
+	if err != nil {
+		return file, err
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return file, err
+	}
+	if file.Hash, err = getContentHash(temp); err != nil {
+		return file, err
+	}
+	file.Size = size
+	return file, nil
+}
+
+func (b *Bucket) saveStreamed(db DB, object string, files []streamedFile) (fileHashes []string, err error) {
+	var records = make([]fileRecord, len(files))
+	var names []string
+	for i := range files {
+		records[i] = files[i].fileRecord
+		fileHashes = append(fileHashes, files[i].Hash)
+		names = append(names, files[i].Name)
+	}
+	err = runInTx(db, func(tx DB) error {
+		if err := b.insertFileRecords(tx, records); err != nil {
+			return err
+		}
+		if object != "" {
+			if err := b.linkNamed(tx, object, fileHashes, names); err != nil {
+				return err
+			}
+		}
+		for i := range files {
+			if err := b.moveFile(files[i].Path, files[i].Hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fileHashes, nil
+}
+
+// moveFile moves a temporary file under Dir to its store path, without copying it on local machine.
+func (b *Bucket) moveFile(srcPath, hash string) error {
+	var destPath = filepath.Join(b.Dir, b.FilePath(hash))
+	if b.localMachine {
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return err
+		}
+		// os.Link never overwrites an existing file.
+		if err := os.Link(srcPath, destPath); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	if err := b.copyToOthers(srcPath, destPath); err != nil {
+		return err
+	}
+	return os.Remove(srcPath)
+}
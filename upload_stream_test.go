@@ -0,0 +1,29 @@
+package filestorage
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+)
+
+func ExampleBucket_UploadStream() {
+	body := `--ZnGpDtePMx0KrHh_G0X99Yef9r8JZsRJSXC
+Content-Disposition: form-data; name="file"; filename="1.gif"
+Content-Type: image/gif
+
+GIF89a-stream
+--ZnGpDtePMx0KrHh_G0X99Yef9r8JZsRJSXC--
+`
+	upload := func(maxSize int64) {
+		req := httptest.NewRequest("POST", "/upload?linkObject=stream", strings.NewReader(
+			strings.Replace(body, "\n", "\r\n", -1),
+		))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=ZnGpDtePMx0KrHh_G0X99Yef9r8JZsRJSXC")
+		fmt.Println(testBucket.UploadStream(req, "en", maxSize))
+	}
+	upload(0)
+	upload(10)
+	// Output:
+	// [C_aKk9rk1pbTJ_yk4Y4BBGLS8DXyxJisALjqW-vn8Ss] <nil>
+	// [] args-err: file size cann't exceed 10 B.
+}
@@ -0,0 +1,118 @@
+package filestorage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+// UploadToken is a signed upload policy, like the S3 POST policy.
+// It's signed by the server, and then browsers can upload files directly with it,
+// all the upload parameters are taken from the token instead of the request.
+// Since the token is authorized when it's signed, Authorizer is not called for uploads with tokens.
+type UploadToken struct {
+	Bucket     string `json:"b"`
+	LinkObject string `json:"o,omitempty"` // If not empty, uploaded files are linked to it.
+	// The name of a policy registered in Policies to check uploaded files.
+	// If it's empty, only images are allowed.
+	Policy  string `json:"p,omitempty"`
+	MaxSize int64  `json:"s,omitempty"` // Max file size, it's applied in addition to Policy.
+	Expires int64  `json:"e"`           // Unix timestamp in seconds.
+}
+
+var errInvalidUploadToken = errs.New("forbidden", "invalid upload token")
+var errUploadTokenExpired = errs.New("forbidden", "upload token expired")
+
+// SignUploadToken signs an upload token which expires after ttl, the token's Bucket is set to b.Name.
+func (b *Bucket) SignUploadToken(token UploadToken, ttl time.Duration) (string, error) {
+	token.Bucket = b.Name
+	token.Expires = time.Now().Add(ttl).Unix()
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	signature, err := b.sign([]byte(payload))
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + signature, nil
+}
+
+// ParseUploadToken verifies an upload token by the SignKeys of its bucket.
+func ParseUploadToken(token string) (*Bucket, UploadToken, error) {
+	t, err := decodeUploadToken(token)
+	if err != nil {
+		return nil, t, err
+	}
+	bucket, err := GetBucket(t.Bucket)
+	if err != nil {
+		return nil, t, err
+	}
+	t, err = bucket.ParseUploadToken(token)
+	return bucket, t, err
+}
+
+// ParseUploadToken verifies an upload token by SignKeys, and checks its bucket and expiry.
+func (b *Bucket) ParseUploadToken(token string) (UploadToken, error) {
+	t, err := decodeUploadToken(token)
+	if err != nil {
+		return t, err
+	}
+	i := strings.IndexByte(token, '.')
+	if t.Bucket != b.Name || !b.verify([]byte(token[:i]), token[i+1:]) {
+		return UploadToken{}, errInvalidUploadToken
+	}
+	if time.Now().Unix() > t.Expires {
+		return UploadToken{}, errUploadTokenExpired
+	}
+	return t, nil
+}
+
+func decodeUploadToken(token string) (UploadToken, error) {
+	var t UploadToken
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return t, errInvalidUploadToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return t, errInvalidUploadToken
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, errInvalidUploadToken
+	}
+	return t, nil
+}
+
+// UploadWithToken upload files in the "file" field of a multipart form,
+// according to the upload token in the "token" query parameter.
+func UploadWithToken(req *http.Request, lang string) ([]string, error) {
+	bucket, token, err := ParseUploadToken(req.URL.Query().Get("token"))
+	if err != nil {
+		return nil, err
+	}
+	checker, err := bucket.tokenChecker(token, lang)
+	if err != nil {
+		return nil, err
+	}
+	return bucket.uploadForm(req, checker, token.LinkObject, token.MaxSize)
+}
+
+func (b *Bucket) tokenChecker(token UploadToken, lang string) (FileChecker, error) {
+	if token.Policy == "" {
+		return ImagePolicy(token.MaxSize).Checker(lang), nil
+	}
+	policy, ok := b.Policies[token.Policy]
+	if !ok {
+		return nil, errUnknownPolicy
+	}
+	if token.MaxSize > 0 {
+		policy = Policy{policy, Size{Max: token.MaxSize}}
+	}
+	return policy.Checker(lang), nil
+}
@@ -0,0 +1,40 @@
+package filestorage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func ExampleBucket_SignUploadToken() {
+	bucket := Bucket{Name: "signed", SignKeys: []string{"key1"}}
+	token, err := bucket.SignUploadToken(UploadToken{LinkObject: "users.1.avatar", MaxSize: 1 << 20}, time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	t, err := bucket.ParseUploadToken(token)
+	fmt.Println(t.Bucket, t.LinkObject, t.MaxSize, err)
+
+	// rotate keys, tokens signed by the old key are still valid.
+	bucket.SignKeys = []string{"key2", "key1"}
+	_, err = bucket.ParseUploadToken(token)
+	fmt.Println(err)
+
+	bucket.SignKeys = []string{"key2"}
+	_, err = bucket.ParseUploadToken(token)
+	fmt.Println(err)
+
+	tampered := strings.Replace(token, token[:strings.IndexByte(token, '.')], "eyJiIjoic2lnbmVkIn0", 1)
+	_, err = bucket.ParseUploadToken(tampered)
+	fmt.Println(err)
+
+	expired, _ := bucket.SignUploadToken(UploadToken{}, -time.Second)
+	_, err = bucket.ParseUploadToken(expired)
+	fmt.Println(err)
+	// Output:
+	// signed users.1.avatar 1048576 <nil>
+	// <nil>
+	// forbidden: invalid upload token
+	// forbidden: invalid upload token
+	// forbidden: upload token expired
+}
@@ -0,0 +1,326 @@
+package filestorage
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+	"golang.org/x/image/draw"
+)
+
+// Variant describes an image variant, which is generated from the original image on first download,
+// and stored as a file whose hash is recorded in the "tranformations" column of the original file.
+type Variant struct {
+	Width  int // Max width, 0 means not limited.
+	Height int // Max height, 0 means not limited.
+	// If Crop is true and both Width and Height are set, the image is scaled to cover Width x Height,
+	// and the center part is cropped, otherwise the image is scaled to fit in Width x Height.
+	// Images are never scaled up.
+	Crop bool
+	// "jpeg", "png" or "gif". Defaults to the original format, or "png" if the original format is not encodable.
+	Format  string
+	Quality int // JPEG quality(1 to 100), defaults to 85.
+}
+
+const (
+	maxVariantSize         = 4096
+	maxVariantSourcePixels = 64 << 20
+)
+
+var errInvalidVariant = errs.New("args-err", "invalid image variant")
+var errVariantNotAllowed = errs.New("forbidden", "image variant not allowed")
+var errVariantSource = errs.New("args-err", "variants are only supported for jpeg, png, gif and webp images")
+
+// IsZero returns if v means the original file.
+func (v Variant) IsZero() bool {
+	return v == Variant{}
+}
+
+// ParseVariant parses a variant from query params of a download url.
+func ParseVariant(q url.Values) (Variant, error) {
+	var v Variant
+	for _, p := range []struct {
+		name  string
+		value *int
+		max   int
+	}{{"w", &v.Width, maxVariantSize}, {"h", &v.Height, maxVariantSize}, {"q", &v.Quality, 100}} {
+		if s := q.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 || n > p.max {
+				return Variant{}, errInvalidVariant
+			}
+			*p.value = n
+		}
+	}
+	v.Crop = q.Get("crop") == "1"
+	switch v.Format = strings.ToLower(q.Get("fmt")); v.Format {
+	case "", "jpeg", "png", "gif":
+	case "jpg":
+		v.Format = "jpeg"
+	default:
+		return Variant{}, errInvalidVariant
+	}
+	return v, nil
+}
+
+// variantAllowed returns if a variant requested by a download url can be generated.
+func (b *Bucket) variantAllowed(v Variant) bool {
+	if v.IsZero() || b.DownloadURLExpiry > 0 {
+		return true
+	}
+	for _, allowed := range b.Variants {
+		if allowed.Format == "jpg" {
+			allowed.Format = "jpeg"
+		}
+		if v == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (v Variant) setQuery(q url.Values) {
+	if v.Width > 0 {
+		q.Set("w", strconv.Itoa(v.Width))
+	}
+	if v.Height > 0 {
+		q.Set("h", strconv.Itoa(v.Height))
+	}
+	if v.Crop {
+		q.Set("crop", "1")
+	}
+	if v.Format != "" {
+		q.Set("fmt", v.Format)
+	}
+	if v.Quality > 0 {
+		q.Set("q", strconv.Itoa(v.Quality))
+	}
+}
+
+// normalize resolves the default values for an original image of contentType,
+// so that equivalent variants have the same key.
+func (v Variant) normalize(contentType string) Variant {
+	if v.Width <= 0 || v.Height <= 0 {
+		v.Crop = false
+	}
+	if v.Format == "" {
+		switch baseMimeType(contentType) {
+		case "image/jpeg":
+			v.Format = "jpeg"
+		case "image/gif":
+			v.Format = "gif"
+		default:
+			v.Format = "png"
+		}
+	}
+	if v.Format != "jpeg" {
+		v.Quality = 0
+	} else if v.Quality <= 0 {
+		v.Quality = 85
+	}
+	return v
+}
+
+// key returns the key in the "tranformations" map, like "200x0-png", "200x200-crop-jpeg-q85".
+func (v Variant) key() string {
+	key := fmt.Sprintf("%dx%d", v.Width, v.Height)
+	if v.Crop {
+		key += "-crop"
+	}
+	key += "-" + v.Format
+	if v.Quality > 0 {
+		key += "-q" + strconv.Itoa(v.Quality)
+	}
+	return key
+}
+
+func isVariantSource(contentType string) bool {
+	switch baseMimeType(contentType) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// generate makes the variant of an image, v should be normalized.
+func (v Variant) generate(file io.ReadSeeker) ([]byte, error) {
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxVariantSourcePixels {
+		return nil, fmt.Errorf("image too large: %dx%d", config.Width, config.Height)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	img = v.scale(img)
+
+	var buf bytes.Buffer
+	switch v.Format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: v.Quality})
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v Variant) scale(img image.Image) image.Image {
+	bounds := img.Bounds()
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	src := bounds
+	var dw, dh int
+	if v.Crop {
+		ratio := math.Min(1, math.Max(float64(v.Width)/w, float64(v.Height)/h))
+		dw = minInt(v.Width, int(math.Round(w*ratio)))
+		dh = minInt(v.Height, int(math.Round(h*ratio)))
+		sw := minInt(bounds.Dx(), int(math.Round(float64(dw)/ratio)))
+		sh := minInt(bounds.Dy(), int(math.Round(float64(dh)/ratio)))
+		min := bounds.Min.Add(image.Pt((bounds.Dx()-sw)/2, (bounds.Dy()-sh)/2))
+		src = image.Rectangle{Min: min, Max: min.Add(image.Pt(sw, sh))}
+	} else {
+		ratio := 1.0
+		if v.Width > 0 {
+			ratio = math.Min(ratio, float64(v.Width)/w)
+		}
+		if v.Height > 0 {
+			ratio = math.Min(ratio, float64(v.Height)/h)
+		}
+		dw = maxInt(1, int(math.Round(w*ratio)))
+		dh = maxInt(1, int(math.Round(h*ratio)))
+	}
+	if src == bounds && dw == bounds.Dx() && dh == bounds.Dy() {
+		return img
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// flatten draws an image on a white background, since JPEG doesn't support transparency.
+func flatten(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// variantOf returns the hash of a variant of file, and the variant normalized for file.
+// The variant is generated if it doesn't exist yet.
+func (b *Bucket) variantOf(db DB, file string, v Variant) (string, Variant, error) {
+	row := b.getDB(db).QueryRow(fmt.Sprintf(
+		`SELECT type, tranformations FROM %s WHERE hash = %s`, b.FilesTable, quote(file),
+	))
+	var contentType string
+	var tranformations []byte
+	if err := row.Scan(&contentType, &tranformations); err != nil {
+		if err == sql.ErrNoRows {
+			return "", v, errFileNotExists
+		}
+		return "", v, err
+	}
+	if !isVariantSource(contentType) {
+		return "", v, errVariantSource
+	}
+	v = v.normalize(contentType)
+
+	var variants map[string]string
+	if err := json.Unmarshal(tranformations, &variants); err != nil {
+		return "", v, err
+	}
+	if hash := variants[v.key()]; hash != "" {
+		if err := b.CheckFile(db, hash); err == nil {
+			return hash, v, nil
+		} else if !IsFileNotExists(err) {
+			return "", v, err
+		}
+	}
+	hash, err := b.generateVariant(db, file, v)
+	return hash, v, err
+}
+
+func (b *Bucket) generateVariant(db DB, file string, v Variant) (string, error) {
+	f, err := b.openLocalFile(file)
+	if err != nil {
+		return "", err
+	}
+	data, err := v.generate(f)
+	f.Close()
+	if err != nil {
+		return "", errs.New("args-err", "unrecognized image.").SetData(err.Error())
+	}
+	content := bytes.NewReader(data)
+	hash, err := getContentHash(content)
+	if err != nil {
+		return "", err
+	}
+	width, height, err := imageSize("image/"+v.Format, content)
+	if err != nil {
+		return "", err
+	}
+
+	err = runInTx(b.getDB(db), func(tx DB) error {
+		// variants are deleted by clean along with their parent, see cleanDB.
+		if _, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s (hash, type, size, width, height, parent, created_at)
+		VALUES (%s, %s, %d, %d, %d, %s, %s)
+		ON CONFLICT (hash) DO NOTHING
+		`, b.FilesTable, quote(hash), quote("image/"+v.Format), len(data), width, height,
+			quote(file), fmtTime(time.Now()),
+		)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`
+		UPDATE %s SET tranformations = tranformations || jsonb_build_object(%s::text, %s::text)
+		WHERE hash = %s
+		`, b.FilesTable, quote(v.key()), quote(hash), quote(file),
+		)); err != nil {
+			return err
+		}
+		return b.saveFile(content, hash)
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
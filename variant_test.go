@@ -0,0 +1,58 @@
+package filestorage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http/httptest"
+)
+
+func ExampleBucket_DownloadURLWithOptions() {
+	bucket := Bucket{Name: "img", DownloadURLPrefix: "http://example.com/files"}
+	fmt.Println(bucket.DownloadURLWithOptions(nil, testFile1, URLOptions{
+		Variant: Variant{Width: 200, Height: 200, Crop: true, Format: "jpeg"},
+	}))
+	// Output:
+	// http://example.com/files?b=img&crop=1&f=TEaLOxaZn9lXgYlXbV93DLShatn8oOeYolHwClSofF1&fmt=jpeg&h=200&w=200
+}
+
+func ExampleBucket_DownloadVariant() {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		panic(err)
+	}
+	hashes, err := testBucket.Save(nil, nil, "variant", File{IO: bytes.NewReader(buf.Bytes()), Size: int64(buf.Len())})
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := httptest.NewRecorder()
+		err := testBucket.DownloadVariant(nil, resp, hashes[0], "variant", Variant{Width: 100, Format: "jpeg"})
+		config, format, _ := image.DecodeConfig(resp.Body)
+		fmt.Println(err, resp.Header().Get("Content-Type"), format, config.Width, config.Height)
+	}
+	variants, err := testBucket.queryFiles(nil, fmt.Sprintf(
+		`SELECT hash FROM %s WHERE parent = %s`, testBucket.FilesTable, quote(hashes[0]),
+	))
+	fmt.Println(len(variants), err)
+	// Output:
+	// <nil> image/jpeg jpeg 100 50
+	// <nil> image/jpeg jpeg 100 50
+	// 1 <nil>
+}
+
+func ExampleBucket_ServeDownload_variants() {
+	bucket := Bucket{Name: "img", Variants: []Variant{{Width: 200, Height: 200, Crop: true, Format: "jpg"}}}
+	for _, variant := range []Variant{{Width: 200, Height: 200, Crop: true, Format: "jpeg"}, {Width: 201}} {
+		fmt.Println(bucket.variantAllowed(variant))
+	}
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/?b=img&w=4096&h=4096&f="+testFile1, nil)
+	fmt.Println(bucket.ServeDownload(nil, req, resp), resp.Code)
+	// Output:
+	// true
+	// false
+	// forbidden: image variant not allowed 403
+}
@@ -0,0 +1,151 @@
+package filestorage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lovego/errs"
+)
+
+var errNoFiles = errs.New("not-found", "no files linked to the object")
+
+// DownloadZipURL make the url to download all files linked to an object as a zip archive,
+// options.Filename is the archive name, options.Variant and options.Disposition are ignored.
+// The url works only if Authorizer is present or download urls are signed, see ServeDownload.
+func (b *Bucket) DownloadZipURL(linkObject interface{}, options URLOptions) string {
+	if linkObject == nil {
+		return ""
+	}
+	options.Variant, options.Disposition = Variant{}, ""
+	q := url.Values{}
+	q.Set("zip", "1")
+	return b.downloadURL(q, linkObject, options)
+}
+
+type zipEntry struct {
+	Hash      string
+	Name      string
+	Type      string
+	CreatedAt time.Time
+}
+
+/*
+DownloadZip streams all files linked to object as a zip archive named filename.
+The archive is built on the fly, entries are named by the display names of links, and duplicate names are
+renamed like "a (1).jpg". Infected files and files missing on all machines are skipped.
+*/
+func (b *Bucket) DownloadZip(db DB, resp http.ResponseWriter, object, filename string) error {
+	if object == "" {
+		resp.WriteHeader(http.StatusBadRequest)
+		return errEmptyObject
+	}
+	entries, err := b.zipEntries(db, object)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		resp.WriteHeader(http.StatusNotFound)
+		return errNoFiles
+	}
+	if filename == "" {
+		filename = "files.zip"
+	}
+	resp.Header().Set("Content-Type", "application/zip")
+	resp.Header().Set("Content-Disposition", ContentDisposition(DispositionAttachment, filename))
+	// the linked files of an object may change.
+	resp.Header().Set("Cache-Control", "no-cache")
+
+	w := zip.NewWriter(resp)
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		if err := b.writeZipEntry(w, entry, uniqueName(names, entry.Name)); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func (b *Bucket) zipEntries(db DB, object string) ([]zipEntry, error) {
+	rows, err := b.getDB(db).Query(fmt.Sprintf(`
+	SELECT l.file, l.name, f.type, f.created_at
+	FROM %s l JOIN %s f ON f.hash = l.file
+	WHERE l.object = %s AND f.infected = ''
+	ORDER BY l.created_at, l.file
+	`, b.LinksTable, b.FilesTable, quote(object),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []zipEntry
+	for rows.Next() {
+		var entry zipEntry
+		if err := rows.Scan(&entry.Hash, &entry.Name, &entry.Type, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if entry.Name == "" {
+			entry.Name = entry.Hash + extensionByType(entry.Type)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (b *Bucket) writeZipEntry(w *zip.Writer, entry zipEntry, name string) error {
+	f, err := b.openFile(entry.Hash)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: entry.CreatedAt}
+	if isCompressed(entry.Type) {
+		header.Method = zip.Store
+	}
+	entryWriter, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entryWriter, f)
+	return err
+}
+
+// isCompressed returns if files of a content type are already compressed, so deflating them is useless.
+func isCompressed(contentType string) bool {
+	typ := baseMimeType(contentType)
+	switch {
+	case strings.HasPrefix(typ, "image/"):
+		return typ != "image/svg+xml" && typ != "image/bmp"
+	case strings.HasPrefix(typ, "video/"), strings.HasPrefix(typ, "audio/"):
+		return true
+	}
+	switch typ {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/java-archive", "application/epub+zip":
+		return true
+	}
+	return strings.HasPrefix(typ, "application/vnd.openxmlformats-officedocument.") ||
+		strings.HasPrefix(typ, "application/vnd.oasis.opendocument.")
+}
+
+// uniqueName renames a name like "a (1).jpg" if it's used, names are compared case-insensitively.
+func uniqueName(used map[string]bool, name string) string {
+	unique := name
+	ext := filepath.Ext(name)
+	for i := 1; used[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
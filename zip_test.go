@@ -0,0 +1,64 @@
+package filestorage
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+)
+
+func ExampleBucket_DownloadZip() {
+	if _, err := testBucket.Save(nil, nil, "zipped",
+		File{IO: strings.NewReader("zip one"), Name: "a.txt", Size: 7},
+		File{IO: strings.NewReader("zip two"), Name: "A.txt", Size: 7},
+	); err != nil {
+		panic(err)
+	}
+	resp := httptest.NewRecorder()
+	if err := testBucket.DownloadZip(nil, resp, "zipped", "报告.zip"); err != nil {
+		panic(err)
+	}
+	fmt.Println(resp.Header().Get("Content-Type"))
+	fmt.Println(resp.Header().Get("Content-Disposition"))
+
+	body := resp.Body.Bytes()
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			panic(err)
+		}
+		content, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		fmt.Println(f.Name, string(content))
+	}
+	fmt.Println(testBucket.DownloadZip(nil, httptest.NewRecorder(), "nothing", ""))
+	// Output:
+	// application/zip
+	// attachment; filename="_.zip"; filename*=UTF-8''%E6%8A%A5%E5%91%8A.zip
+	// a.txt zip one
+	// A (1).txt zip two
+	// not-found: no files linked to the object
+}
+
+func ExampleBucket_DownloadZipURL() {
+	bucket := Bucket{Name: "zip", DownloadURLPrefix: "http://example.com/files"}
+	fmt.Println(bucket.DownloadZipURL("object", URLOptions{Filename: "all.zip"}))
+	// Output:
+	// http://example.com/files?b=zip&filename=all.zip&o=object&zip=1
+}
+
+func ExampleBucket_ServeDownload_zip() {
+	// zip downloads are refused without Authorizer or signed urls, since link objects are easy to guess.
+	bucket := Bucket{Name: "zip", DownloadURLPrefix: "http://example.com/files"}
+	resp := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", bucket.DownloadZipURL("orders|1|attachments", URLOptions{}), nil)
+	fmt.Println(bucket.ServeDownload(nil, req, resp), resp.Code)
+	// Output:
+	// forbidden: access denied 403
+}